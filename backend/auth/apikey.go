@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"codecollab/config"
+)
+
+func init() {
+	RegisterProvider("apikey", newAPIKeyAuthenticator)
+}
+
+// APIKey is an entry of the API keys file. Either the key itself or the hex
+// SHA-256 of it is given, so the file need not hold usable keys.
+type APIKey struct {
+	Key    string   `json:"key,omitempty"`
+	SHA256 string   `json:"sha256,omitempty"`
+	UserID string   `json:"userId"`
+	Email  string   `json:"email,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// APIKeyAuthenticator accepts a fixed set of keys, for services and scripts
+// that have no user account with the identity provider
+type APIKeyAuthenticator struct {
+	// keys by the SHA-256 of the key, so lookups do not compare secrets
+	keys map[[sha256.Size]byte]*Principal
+}
+
+func newAPIKeyAuthenticator(cfg *config.Config) (Authenticator, error) {
+	if cfg.APIKeysFile == "" {
+		return nil, errors.New("AUTH_API_KEYS_FILE is not set")
+	}
+
+	data, err := os.ReadFile(cfg.APIKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	return NewAPIKeyAuthenticator(keys)
+}
+
+func NewAPIKeyAuthenticator(keys []APIKey) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{
+		keys: make(map[[sha256.Size]byte]*Principal, len(keys)),
+	}
+
+	for i, key := range keys {
+		if key.UserID == "" {
+			return nil, fmt.Errorf("API key %d has no userId", i)
+		}
+
+		var hash [sha256.Size]byte
+		switch {
+		case key.Key != "":
+			hash = sha256.Sum256([]byte(key.Key))
+		case key.SHA256 != "":
+			b, err := hex.DecodeString(strings.TrimSpace(key.SHA256))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("API key %d has an invalid sha256", i)
+			}
+			copy(hash[:], b)
+		default:
+			return nil, fmt.Errorf("API key %d has no key or sha256", i)
+		}
+
+		if _, exists := a.keys[hash]; exists {
+			return nil, fmt.Errorf("API key %d is listed twice", i)
+		}
+		a.keys[hash] = &Principal{
+			UserID:   key.UserID,
+			Email:    key.Email,
+			Roles:    key.Roles,
+			Tenant:   key.Tenant,
+			Provider: "apikey",
+		}
+	}
+
+	logger.Info("Loaded %d API keys", len(a.keys))
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	principal, exists := a.keys[sha256.Sum256([]byte(token))]
+	if !exists {
+		return nil, errors.New("unknown API key")
+	}
+
+	// Callers may keep the principal, so each gets its own copy
+	p := *principal
+	p.Roles = append([]string(nil), principal.Roles...)
+	return &p, nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"codecollab/config"
+)
+
+// Principal is an authenticated user
+type Principal struct {
+	UserID   string   `json:"userId"`
+	Email    string   `json:"email,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Provider string   `json:"provider"`
+
+	// SessionID is the identity provider's login session, if it names one
+	SessionID string `json:"sessionId,omitempty"`
+	// IssuedAt and ExpiresAt are those of the credential, zero for
+	// credentials that do not expire
+	IssuedAt  time.Time `json:"issuedAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// HasRole reports whether the principal was given the role by its provider
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Authenticator turns the credential presented with a request into the
+// principal it belongs to
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// Factory builds an Authenticator from the configuration
+type Factory func(cfg *config.Config) (Authenticator, error)
+
+// ErrMissingToken is returned for requests without a credential
+var ErrMissingToken = errors.New("missing auth token")
+
+var (
+	providers   = make(map[string]Factory)
+	providersMu sync.RWMutex
+)
+
+// RegisterProvider makes an authentication provider selectable by name from
+// configuration
+func RegisterProvider(name string, factory Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, exists := providers[name]; exists {
+		panic("auth: provider registered twice: " + name)
+	}
+	providers[name] = factory
+}
+
+// New builds the authenticator for the configured providers. With more than
+// one, a token is accepted by the first provider that accepts it.
+func New(cfg *config.Config) (Authenticator, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	if len(cfg.AuthProviders) == 0 {
+		return nil, errors.New("no auth provider configured")
+	}
+
+	var chain Chain
+	for _, name := range cfg.AuthProviders {
+		factory, exists := providers[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown auth provider %q", name)
+		}
+
+		authenticator, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s authenticator: %w", name, err)
+		}
+		chain = append(chain, authenticator)
+		logger.Info("Registered %s auth provider", name)
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+// Chain tries each authenticator in turn
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	var errs []error
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
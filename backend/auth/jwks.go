@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"codecollab/utils"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var logger = utils.NewLogger("auth")
+
+var (
+	// ErrKeyNotFound is returned when a key set has no key with the token's key ID
+	ErrKeyNotFound = errors.New("signing key not found")
+	// ErrKeysUnavailable is returned when there is no key to verify a token
+	// with, so it can be neither accepted nor rejected locally
+	ErrKeysUnavailable = errors.New("signing keys unavailable")
+)
+
+const (
+	// minJWKSRefetch limits how often the key set is fetched, so tokens with
+	// unknown key IDs or an unreachable URL cannot cause a fetch per request
+	minJWKSRefetch = time.Minute
+
+	maxJWKSSize = 1 << 20
+)
+
+// KeySource looks up the public keys that sign tokens by key ID
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeySource is a fixed key set, for tests and deployments that
+// configure their keys directly
+type StaticKeySource map[string]crypto.PublicKey
+
+func (s StaticKeySource) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, exists := s[kid]; exists {
+		return key, nil
+	}
+	// A token without a key ID can still be verified by a set of one key
+	if kid == "" && len(s) == 1 {
+		for _, key := range s {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads the RSA and P-256 signing keys of a JSON Web Key Set.
+// Keys of other types or for other uses are skipped.
+func ParseJWKS(data []byte) (StaticKeySource, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(StaticKeySource, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Warn("Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns nil for key types that cannot sign the accepted algorithms
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid y coordinate")
+		}
+		// The uncompressed point encoding validates that it is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSCache fetches a key set from a URL when first needed, again every
+// refresh interval in the background, and when a token names a key it does
+// not have. Fetches happen outside the lock, so verifications with cached
+// keys never wait for the URL, and concurrent misses share one fetch. If a
+// fetch fails the keys already fetched keep being used.
+type JWKSCache struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu      sync.Mutex
+	keys    StaticKeySource
+	triedAt time.Time
+
+	fetches singleflight.Group
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewJWKSCache starts refreshing the key set in the background until the
+// cache is closed
+func NewJWKSCache(url string, refreshInterval time.Duration) *JWKSCache {
+	c := &JWKSCache{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 5 * time.Second},
+		done:            make(chan struct{}),
+	}
+
+	if refreshInterval > 0 {
+		go c.refreshPeriodically()
+	}
+
+	return c
+}
+
+func (c *JWKSCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+
+	if keys != nil {
+		key, err := keys.Key(ctx, kid)
+		if !errors.Is(err, ErrKeyNotFound) {
+			return key, err
+		}
+		// The keys may have been rotated since the last fetch
+	}
+
+	keys = c.refresh(ctx)
+	if keys == nil {
+		return nil, fmt.Errorf("%w: no key set from %s", ErrKeysUnavailable, c.url)
+	}
+	return keys.Key(ctx, kid)
+}
+
+// Close stops the background refresh
+func (c *JWKSCache) Close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (c *JWKSCache) refreshPeriodically() {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh(context.Background())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// refresh fetches the key set, at most once per minJWKSRefetch, and returns
+// the latest keys. Callers arriving during a fetch wait for it, or until
+// their context is done.
+func (c *JWKSCache) refresh(ctx context.Context) StaticKeySource {
+	result := c.fetches.DoChan("", func() (interface{}, error) {
+		c.mu.Lock()
+		now := time.Now()
+		if !c.triedAt.IsZero() && now.Sub(c.triedAt) < minJWKSRefetch {
+			c.mu.Unlock()
+			return nil, nil
+		}
+		c.triedAt = now
+		c.mu.Unlock()
+
+		// Not the caller's context, since other callers share the fetch;
+		// the client's timeout bounds it
+		keys, err := c.fetch(context.Background())
+		if err != nil {
+			logger.Error("Failed to fetch JWKS from %s: %v", c.url, err)
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.keys = keys
+		c.mu.Unlock()
+		logger.Info("Fetched %d signing keys from %s", len(keys), c.url)
+		return nil, nil
+	})
+
+	select {
+	case <-result:
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keys
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) (StaticKeySource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultLeeway allows for clock skew between the token issuer and this server
+const defaultLeeway = 30 * time.Second
+
+// Claims are the claims of a verified token
+type Claims map[string]interface{}
+
+// Subject is the user the token was issued to
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns a string claim, or "" if it is missing or not a string.
+// Claims nested in objects are named by a dotted path, e.g.
+// "app_metadata.tenant_id".
+func (c Claims) String(path string) string {
+	value, _ := c.lookup(path).(string)
+	return value
+}
+
+// Strings returns a claim holding a list of strings, or a single string of
+// space-separated values
+func (c Claims) Strings(path string) []string {
+	switch value := c.lookup(path).(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// Time returns a NumericDate claim such as "exp", or the zero time
+func (c Claims) Time(name string) time.Time {
+	switch value := c.lookup(name).(type) {
+	case float64:
+		return time.Unix(0, int64(value*float64(time.Second)))
+	case json.Number:
+		if f, err := value.Float64(); err == nil {
+			return time.Unix(0, int64(f*float64(time.Second)))
+		}
+	}
+	return time.Time{}
+}
+
+func (c Claims) lookup(path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	var value interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// JWTVerifier checks the signature, expiry, audience and issuer of tokens
+// without calling the service that issued them
+type JWTVerifier struct {
+	secret   []byte
+	keys     KeySource
+	audience string
+	issuer   string
+	leeway   time.Duration
+}
+
+// NewJWTVerifier verifies HS256 tokens with the secret, when set, and
+// RS256/ES256 tokens with keys from the key source, when not nil. Empty
+// audience or issuer are not checked.
+func NewJWTVerifier(secret string, keys KeySource, audience, issuer string) *JWTVerifier {
+	v := &JWTVerifier{
+		keys:     keys,
+		audience: audience,
+		issuer:   issuer,
+		leeway:   defaultLeeway,
+	}
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+	return v
+}
+
+// Verify parses the token and returns its claims if it is valid. The error
+// wraps ErrKeysUnavailable when the verifier has no key for the token.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.leeway),
+	}
+	if v.audience != "" {
+		options = append(options, jwt.WithAudience(v.audience))
+	}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return v.key(ctx, t)
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+	if Claims(claims).Subject() == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return Claims(claims), nil
+}
+
+func (v *JWTVerifier) key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	if _, hmac := t.Method.(*jwt.SigningMethodHMAC); hmac {
+		if v.secret == nil {
+			return nil, fmt.Errorf("%w: no secret for %s tokens", ErrKeysUnavailable, t.Method.Alg())
+		}
+		return v.secret, nil
+	}
+
+	if v.keys == nil {
+		return nil, fmt.Errorf("%w: no key set for %s tokens", ErrKeysUnavailable, t.Method.Alg())
+	}
+	kid, _ := t.Header["kid"].(string)
+	return v.keys.Key(ctx, kid)
+}
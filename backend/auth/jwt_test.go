@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testSecret   = "test-secret-at-least-32-bytes-long"
+	testAudience = "authenticated"
+	testIssuer   = "https://project.supabase.co/auth/v1"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"aud": testAudience,
+		"iss": testIssuer,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTVerifier(t *testing.T) {
+	keys := StaticKeySource{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	verifier := NewJWTVerifier(testSecret, keys, testAudience, testIssuer)
+
+	publicPEM, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicPEM})
+
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256", sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte(testSecret)), true},
+		{"RS256", sign(t, jwt.SigningMethodRS256, "rsa", validClaims(), rsaKey), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, "ec", validClaims(), ecKey), true},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", with("exp", time.Now().Add(-time.Hour).Unix()), rsaKey), false},
+		{"no expiry", sign(t, jwt.SigningMethodRS256, "rsa", with("exp", nil), rsaKey), false},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", with("aud", "other"), rsaKey), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", with("iss", "https://evil.example.com"), rsaKey), false},
+		{"no subject", sign(t, jwt.SigningMethodHS256, "", with("sub", nil), []byte(testSecret)), false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("another-secret-at-least-32-bytes")), false},
+		{"key of another algorithm", sign(t, jwt.SigningMethodES256, "rsa", validClaims(), ecKey), false},
+		{"HS256 signed with the RSA public key", sign(t, jwt.SigningMethodHS256, "rsa", validClaims(), publicPEM), false},
+		{"alg none", sign(t, jwt.SigningMethodNone, "", validClaims(), jwt.UnsafeAllowNoneSignatureType), false},
+		{"RS384", sign(t, jwt.SigningMethodRS384, "rsa", validClaims(), rsaKey), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), tt.token)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("Verify = %v, want valid", err)
+				}
+				if claims.Subject() != "user-1" {
+					t.Errorf("subject = %q, want user-1", claims.Subject())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Verify accepted an invalid token")
+			}
+		})
+	}
+}
+
+func TestJWTVerifierWithoutSecret(t *testing.T) {
+	// A verifier for asymmetric keys only must not treat the public key as
+	// an HMAC secret
+	verifier := NewJWTVerifier("", StaticKeySource{"rsa": &rsaKey.PublicKey}, testAudience, testIssuer)
+
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{der, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})} {
+		token := sign(t, jwt.SigningMethodHS256, "rsa", validClaims(), key)
+		if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrKeysUnavailable) {
+			t.Errorf("Verify = %v, want ErrKeysUnavailable", err)
+		}
+	}
+}
+
+// jwksServer serves the keys it holds and counts the requests for them
+type jwksServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	requests int
+}
+
+func newJWKSServer(t *testing.T, keys map[string]crypto.PublicKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests++
+		set := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, toJWK(t, kid, key))
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(kid string, key crypto.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func toJWK(t *testing.T, kid string, key crypto.PublicKey) jwk {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kid: kid, Kty: "RSA", Use: "sig", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		point, err := key.Bytes()
+		if err != nil {
+			t.Error(err)
+		}
+		return jwk{Kid: kid, Kty: "EC", Use: "sig", Crv: "P-256", X: encode(point[1:33]), Y: encode(point[33:])}
+	}
+	t.Errorf("unexpected key type %T", key)
+	return jwk{}
+}
+
+func TestJWKSCacheRefetchesUnknownKeys(t *testing.T) {
+	server := newJWKSServer(t, map[string]crypto.PublicKey{"old": &rsaKey.PublicKey})
+	cache := NewJWKSCache(server.URL, 0)
+	defer cache.Close()
+	verifier := NewJWTVerifier("", cache, testAudience, testIssuer)
+	ctx := context.Background()
+
+	if _, err := verifier.Verify(ctx, sign(t, jwt.SigningMethodRS256, "old", validClaims(), rsaKey)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(ctx, sign(t, jwt.SigningMethodRS256, "old", validClaims(), rsaKey)); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.count(); n != 1 {
+		t.Fatalf("fetched the key set %d times for known keys, want 1", n)
+	}
+
+	// The issuer rotates its keys after the last fetch was allowed
+	server.set("new", &ecKey.PublicKey)
+	cache.mu.Lock()
+	cache.triedAt = time.Now().Add(-minJWKSRefetch)
+	cache.mu.Unlock()
+
+	if _, err := verifier.Verify(ctx, sign(t, jwt.SigningMethodES256, "new", validClaims(), ecKey)); err != nil {
+		t.Fatalf("token signed with a rotated key: %v", err)
+	}
+	if n := server.count(); n != 2 {
+		t.Fatalf("fetched the key set %d times after an unknown key, want 2", n)
+	}
+
+	// Another unknown key straight after does not fetch again
+	if _, err := verifier.Verify(ctx, sign(t, jwt.SigningMethodES256, "unknown", validClaims(), ecKey)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("token signed with an unknown key: %v, want ErrKeyNotFound", err)
+	}
+	if n := server.count(); n != 2 {
+		t.Fatalf("fetched the key set %d times, want no more than 2 within a minute", n)
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"context"
+
+	"codecollab/config"
+)
+
+func init() {
+	RegisterProvider("mock", func(cfg *config.Config) (Authenticator, error) {
+		return MockAuthenticator{}, nil
+	})
+}
+
+// MockAuthenticator accepts any token, naming the user after its start. It
+// is only for local development.
+type MockAuthenticator struct{}
+
+func (MockAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	logger.Info("Using mock auth - accepting token: %s", token[:min(10, len(token))])
+	return &Principal{
+		UserID:   "mock-user-" + token[:min(8, len(token))],
+		Provider: "mock",
+	}, nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"codecollab/config"
+)
+
+func init() {
+	RegisterProvider("oidc", newOIDCAuthenticator)
+}
+
+// OIDCAuthenticator verifies tokens from any OpenID Connect provider, using
+// the signing keys named by the issuer's discovery document
+type OIDCAuthenticator struct {
+	verifier    *JWTVerifier
+	rolesClaim  string
+	tenantClaim string
+}
+
+func newOIDCAuthenticator(cfg *config.Config) (Authenticator, error) {
+	if cfg.OIDCIssuerURL == "" {
+		return nil, errors.New("OIDC_ISSUER_URL is not set")
+	}
+	if cfg.OIDCAudience == "" {
+		return nil, errors.New("OIDC_AUDIENCE is not set")
+	}
+
+	issuer := strings.TrimSuffix(cfg.OIDCIssuerURL, "/")
+	keys := &discoveredKeys{
+		issuer:          issuer,
+		refreshInterval: cfg.JWKSRefreshInterval,
+		client:          &http.Client{Timeout: 5 * time.Second},
+	}
+
+	return &OIDCAuthenticator{
+		// The iss claim must match the configured issuer exactly, including
+		// any trailing slash
+		verifier:    NewJWTVerifier("", keys, cfg.OIDCAudience, cfg.OIDCIssuerURL),
+		rolesClaim:  cfg.OIDCRolesClaim,
+		tenantClaim: cfg.OIDCTenantClaim,
+	}, nil
+}
+
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	return &Principal{
+		UserID:   claims.Subject(),
+		Email:    claims.String("email"),
+		Roles:    claims.Strings(a.rolesClaim),
+		Tenant:   claims.String(a.tenantClaim),
+		Provider: "oidc",
+
+		SessionID: claims.String("sid"),
+		IssuedAt:  claims.Time("iat"),
+		ExpiresAt: claims.Time("exp"),
+	}, nil
+}
+
+// discoveredKeys finds the issuer's key set from its discovery document the
+// first time a key is needed, retrying at most once per minJWKSRefetch while
+// discovery fails
+type discoveredKeys struct {
+	issuer          string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu      sync.Mutex
+	keys    *JWKSCache
+	triedAt time.Time
+}
+
+func (d *discoveredKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	keys, err := d.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return keys.Key(ctx, kid)
+}
+
+func (d *discoveredKeys) discover(ctx context.Context) (*JWKSCache, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.keys != nil {
+		return d.keys, nil
+	}
+
+	now := time.Now()
+	if !d.triedAt.IsZero() && now.Sub(d.triedAt) < minJWKSRefetch {
+		return nil, fmt.Errorf("%w: discovery of %s failed", ErrKeysUnavailable, d.issuer)
+	}
+	d.triedAt = now
+
+	jwksURL, err := d.fetchDiscovery(ctx)
+	if err != nil {
+		logger.Error("Failed to discover OIDC configuration of %s: %v", d.issuer, err)
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	logger.Info("Discovered JWKS of %s at %s", d.issuer, jwksURL)
+	d.keys = NewJWKSCache(jwksURL, d.refreshInterval)
+	return d.keys, nil
+}
+
+// fetchDiscovery returns the JWKS URL of the issuer
+func (d *discoveredKeys) fetchDiscovery(ctx context.Context) (string, error) {
+	url := d.issuer + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&discovery); err != nil {
+		return "", fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != d.issuer {
+		return "", fmt.Errorf("discovery document is for issuer %q", discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("discovery document has no jwks_uri")
+	}
+	return discovery.JWKSURI, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRevoked is returned for credentials an administrator has revoked
+	ErrRevoked = errors.New("credentials revoked")
+	// ErrRevocationNotFound is returned when lifting a revocation that does not exist
+	ErrRevocationNotFound = errors.New("revocation not found")
+)
+
+// Revocation withdraws a user's credentials issued before it, or every
+// credential of one login session, until it expires. A ban withdraws the
+// user's credentials issued after it too.
+type Revocation struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Ban       bool      `json:"ban,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy string    `json:"revokedBy"`
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Matches reports whether the revocation applies to the principal. A user
+// revocation covers credentials issued up to when it was made, so the user
+// can sign in again; credentials without an issue time are covered until it
+// expires. A ban covers all of the user's credentials.
+func (r *Revocation) Matches(p *Principal) bool {
+	if r.SessionID != "" {
+		return p.SessionID == r.SessionID && (r.UserID == "" || p.UserID == r.UserID)
+	}
+	return p.UserID == r.UserID && (r.Ban || !p.IssuedAt.After(r.RevokedAt))
+}
+
+// Revocations is the list of revoked credentials, kept in memory. Entries
+// expire after the configured time, by which the credentials they cover
+// should have expired too.
+type Revocations struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	entries   []Revocation
+	listeners []func(Revocation)
+}
+
+func NewRevocations(ttl time.Duration) *Revocations {
+	return &Revocations{ttl: ttl}
+}
+
+// OnRevoke registers a function called with every new revocation, to end the
+// sessions it covers
+func (r *Revocations) OnRevoke(fn func(Revocation)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, fn)
+}
+
+// Revoke adds a revocation by user ID, session ID or both, or bans the user
+func (r *Revocations) Revoke(userID, sessionID string, ban bool, reason, revokedBy string) (Revocation, error) {
+	if userID == "" && sessionID == "" {
+		return Revocation{}, errors.New("a user or session to revoke is required")
+	}
+	if ban && (userID == "" || sessionID != "") {
+		return Revocation{}, errors.New("a ban applies to a user, not a session")
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	now := time.Now()
+	revocation := Revocation{
+		ID:        hex.EncodeToString(b),
+		UserID:    userID,
+		SessionID: sessionID,
+		Ban:       ban,
+		Reason:    reason,
+		RevokedBy: revokedBy,
+		RevokedAt: now,
+		ExpiresAt: now.Add(r.ttl),
+	}
+
+	r.mu.Lock()
+	r.prune(now)
+	r.entries = append(r.entries, revocation)
+	listeners := append([]func(Revocation){}, r.listeners...)
+	r.mu.Unlock()
+
+	if ban {
+		logger.Warn("User %s banned user %q: %s", revokedBy, userID, reason)
+	} else {
+		logger.Warn("User %s revoked credentials of user %q session %q: %s", revokedBy, userID, sessionID, reason)
+	}
+	for _, fn := range listeners {
+		fn(revocation)
+	}
+	return revocation, nil
+}
+
+// Lift removes a revocation before it expires
+func (r *Revocations) Lift(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, revocation := range r.entries {
+		if revocation.ID == id {
+			r.entries = append(r.entries[:i:i], r.entries[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRevocationNotFound
+}
+
+// List returns the revocations in effect, newest first
+func (r *Revocations) List() []Revocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	entries := append([]Revocation{}, r.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RevokedAt.After(entries[j].RevokedAt)
+	})
+	return entries
+}
+
+// Check returns ErrRevoked if a revocation in effect covers the principal
+func (r *Revocations) Check(p *Principal) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	for i := range r.entries {
+		if now.Before(r.entries[i].ExpiresAt) && r.entries[i].Matches(p) {
+			return ErrRevoked
+		}
+	}
+	return nil
+}
+
+// Wrap returns an authenticator that rejects principals with revoked
+// credentials
+func (r *Revocations) Wrap(authenticator Authenticator) Authenticator {
+	return &revocationChecker{next: authenticator, revocations: r}
+}
+
+// prune must be called with the write lock held
+func (r *Revocations) prune(now time.Time) {
+	valid := r.entries[:0]
+	for _, revocation := range r.entries {
+		if now.Before(revocation.ExpiresAt) {
+			valid = append(valid, revocation)
+		}
+	}
+	r.entries = valid
+}
+
+type revocationChecker struct {
+	next        Authenticator
+	revocations *Revocations
+}
+
+func (c *revocationChecker) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	principal, err := c.next.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.revocations.Check(principal); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevocationsCheck(t *testing.T) {
+	before := time.Now().Add(-time.Minute)
+	after := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name      string
+		userID    string
+		sessionID string
+		ban       bool
+		principal Principal
+		revoked   bool
+	}{
+		{"user, issued before", "user-1", "", false, Principal{UserID: "user-1", IssuedAt: before}, true},
+		{"user, issued after", "user-1", "", false, Principal{UserID: "user-1", IssuedAt: after}, false},
+		{"user, no issue time", "user-1", "", false, Principal{UserID: "user-1"}, true},
+		{"user, another user", "user-1", "", false, Principal{UserID: "user-2", IssuedAt: before}, false},
+		{"ban, issued before", "user-1", "", true, Principal{UserID: "user-1", IssuedAt: before}, true},
+		{"ban, issued after", "user-1", "", true, Principal{UserID: "user-1", IssuedAt: after}, true},
+		{"ban, another user", "user-1", "", true, Principal{UserID: "user-2", IssuedAt: after}, false},
+		{"session", "", "sid", false, Principal{UserID: "user-1", SessionID: "sid", IssuedAt: after}, true},
+		{"session, another session", "", "sid", false, Principal{UserID: "user-1", SessionID: "other"}, false},
+		{"session of another user", "user-2", "sid", false, Principal{UserID: "user-1", SessionID: "sid"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revocations := NewRevocations(time.Hour)
+			if _, err := revocations.Revoke(tt.userID, tt.sessionID, tt.ban, "", "admin"); err != nil {
+				t.Fatal(err)
+			}
+			err := revocations.Check(&tt.principal)
+			if revoked := errors.Is(err, ErrRevoked); revoked != tt.revoked {
+				t.Errorf("Check = %v, want revoked %v", err, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestRevocationsRevokeAndLift(t *testing.T) {
+	revocations := NewRevocations(time.Hour)
+	for _, invalid := range []struct {
+		userID, sessionID string
+		ban               bool
+	}{{"", "", false}, {"", "sid", true}, {"user-1", "sid", true}} {
+		if _, err := revocations.Revoke(invalid.userID, invalid.sessionID, invalid.ban, "", "admin"); err == nil {
+			t.Errorf("Revoke(%q, %q, ban %v) succeeded", invalid.userID, invalid.sessionID, invalid.ban)
+		}
+	}
+
+	var notified []Revocation
+	revocations.OnRevoke(func(r Revocation) { notified = append(notified, r) })
+	ban, err := revocations.Revoke("user-1", "", true, "abuse", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || notified[0].ID != ban.ID {
+		t.Fatalf("listeners told of %v, want the ban", notified)
+	}
+
+	principal := &Principal{UserID: "user-1", IssuedAt: time.Now().Add(time.Minute)}
+	if err := revocations.Lift(ban.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := revocations.Check(principal); err != nil {
+		t.Errorf("Check after lifting the ban = %v", err)
+	}
+	if err := revocations.Lift(ban.ID); !errors.Is(err, ErrRevocationNotFound) {
+		t.Errorf("Lift of a lifted revocation = %v, want ErrRevocationNotFound", err)
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"codecollab/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func init() {
+	RegisterProvider("supabase", newSupabaseAuthenticator)
+}
+
+// SupabaseAuthenticator verifies Supabase access tokens locally, and asks
+// Supabase about tokens it has no key for when remote verification is on
+type SupabaseAuthenticator struct {
+	verifier    *JWTVerifier
+	url         string
+	anonKey     string
+	remote      bool
+	rolesClaim  string
+	tenantClaim string
+	client      *http.Client
+}
+
+func newSupabaseAuthenticator(cfg *config.Config) (Authenticator, error) {
+	var keys KeySource
+	if cfg.SupabaseJWKSURL != "" {
+		keys = NewJWKSCache(cfg.SupabaseJWKSURL, cfg.JWKSRefreshInterval)
+	}
+
+	a := &SupabaseAuthenticator{
+		url:         cfg.SupabaseURL,
+		anonKey:     cfg.SupabaseAnonKey,
+		remote:      cfg.SupabaseRemoteVerify,
+		rolesClaim:  cfg.SupabaseRolesClaim,
+		tenantClaim: cfg.SupabaseTenantClaim,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+	if cfg.SupabaseJWTSecret != "" || keys != nil {
+		a.verifier = NewJWTVerifier(cfg.SupabaseJWTSecret, keys, cfg.SupabaseJWTAudience, cfg.SupabaseJWTIssuer)
+	}
+
+	if a.verifier == nil && !a.remote {
+		return nil, errors.New("no JWT secret or JWKS URL configured and remote verification is disabled")
+	}
+	if a.remote && (a.url == "" || a.anonKey == "") {
+		if a.verifier == nil {
+			return nil, errors.New("Supabase configuration missing")
+		}
+		a.remote = false
+	}
+	return a, nil
+}
+
+func (a *SupabaseAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	if a.verifier != nil {
+		claims, err := a.verifier.Verify(ctx, token)
+		if err == nil {
+			return a.principal(claims), nil
+		}
+		if !a.remote || !errors.Is(err, ErrKeysUnavailable) {
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
+		logger.Warn("Verifying token with Supabase: %v", err)
+	}
+
+	claims, err := a.verifyRemote(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return a.principal(claims), nil
+}
+
+func (a *SupabaseAuthenticator) principal(claims Claims) *Principal {
+	return &Principal{
+		UserID:   claims.Subject(),
+		Email:    claims.String("email"),
+		Roles:    claims.Strings(a.rolesClaim),
+		Tenant:   claims.String(a.tenantClaim),
+		Provider: "supabase",
+
+		SessionID: claims.String("session_id"),
+		IssuedAt:  claims.Time("iat"),
+		ExpiresAt: claims.Time("exp"),
+	}
+}
+
+// verifyRemote asks Supabase for the token's user, returning it as claims
+func (a *SupabaseAuthenticator) verifyRemote(ctx context.Context, token string) (Claims, error) {
+	url := fmt.Sprintf("%s/auth/v1/user", a.url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("apikey", a.anonKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("invalid token: %s", string(body))
+	}
+
+	var user Claims
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to decode user: %w", err)
+	}
+	if id := user.String("id"); id != "" {
+		user["sub"] = id
+	}
+	if user.Subject() == "" {
+		return nil, errors.New("Supabase returned a user without an ID")
+	}
+
+	// Supabase has accepted the token, so its own claims can be trusted for
+	// when it expires
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, unverified); err == nil {
+		for _, name := range []string{"exp", "iat", "session_id"} {
+			if value, exists := unverified[name]; exists {
+				user[name] = value
+			}
+		}
+	}
+
+	logger.Info("Token verified by Supabase for user: %s", user.Subject())
+	return user, nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTicket is returned for tickets that were never issued, have
+// expired or have already been used
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// TicketStore issues short-lived, single-use tickets standing in for an
+// access token, so browsers can open a WebSocket without putting the token
+// in the URL. Tickets are kept in memory and only work on the instance that
+// issued them.
+type TicketStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	tickets  map[string]ticket
+	prunedAt time.Time
+}
+
+type ticket struct {
+	principal *Principal
+	expiresAt time.Time
+}
+
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	return &TicketStore{
+		ttl:     ttl,
+		tickets: make(map[string]ticket),
+	}
+}
+
+// Issue returns a new ticket for the principal and when it expires
+func (s *TicketStore) Issue(principal *Principal) (string, time.Time) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+	s.tickets[id] = ticket{principal: principal, expiresAt: expiresAt}
+
+	return id, expiresAt
+}
+
+// Redeem returns the principal a ticket was issued to. A ticket can only be
+// redeemed once.
+func (s *TicketStore) Redeem(id string) (*Principal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, exists := s.tickets[id]
+	if !exists {
+		return nil, ErrInvalidTicket
+	}
+	delete(s.tickets, id)
+
+	if !time.Now().Before(t.expiresAt) {
+		return nil, ErrInvalidTicket
+	}
+	return t.principal, nil
+}
+
+// prune must be called with the lock held. It drops expired tickets at most
+// once per ticket lifetime.
+func (s *TicketStore) prune(now time.Time) {
+	if now.Sub(s.prunedAt) < s.ttl {
+		return
+	}
+	s.prunedAt = now
+
+	for id, t := range s.tickets {
+		if !now.Before(t.expiresAt) {
+			delete(s.tickets, id)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTicketStore(t *testing.T) {
+	principal := &Principal{UserID: "user-1"}
+
+	tests := []struct {
+		name string
+		// redeem redeems a ticket issued by the store, or another one
+		redeem func(s *TicketStore, id string) (*Principal, error)
+		valid  bool
+	}{
+		{
+			name:   "issued",
+			redeem: func(s *TicketStore, id string) (*Principal, error) { return s.Redeem(id) },
+			valid:  true,
+		},
+		{
+			name:   "never issued",
+			redeem: func(s *TicketStore, id string) (*Principal, error) { return s.Redeem(id + "0") },
+		},
+		{
+			name: "used",
+			redeem: func(s *TicketStore, id string) (*Principal, error) {
+				s.Redeem(id)
+				return s.Redeem(id)
+			},
+		},
+		{
+			name: "expired",
+			redeem: func(s *TicketStore, id string) (*Principal, error) {
+				s.tickets[id] = ticket{principal: principal, expiresAt: time.Now()}
+				return s.Redeem(id)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTicketStore(time.Minute)
+			id, expiresAt := s.Issue(principal)
+			if until := time.Until(expiresAt); until <= 0 || until > time.Minute {
+				t.Fatalf("ticket expires in %v, want within a minute", until)
+			}
+
+			got, err := tt.redeem(s, id)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidTicket) {
+					t.Fatalf("Redeem = %v, %v, want ErrInvalidTicket", got, err)
+				}
+				return
+			}
+			if err != nil || got != principal {
+				t.Fatalf("Redeem = %v, %v, want the principal", got, err)
+			}
+		})
+	}
+}
+
+func TestTicketStoreRedeemsOnce(t *testing.T) {
+	s := NewTicketStore(time.Minute)
+	id, _ := s.Issue(&Principal{UserID: "user-1"})
+
+	var redeemed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Redeem(id); err == nil {
+				redeemed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := redeemed.Load(); n != 1 {
+		t.Fatalf("ticket redeemed %d times, want once", n)
+	}
+}
+
+func TestTicketStorePrunesExpired(t *testing.T) {
+	s := NewTicketStore(time.Minute)
+	expired, _ := s.Issue(&Principal{UserID: "user-1"})
+	s.tickets[expired] = ticket{expiresAt: time.Now().Add(-time.Second)}
+	s.prunedAt = time.Now().Add(-time.Minute)
+
+	valid, _ := s.Issue(&Principal{UserID: "user-2"})
+	if _, exists := s.tickets[expired]; exists {
+		t.Error("expired ticket kept after issuing another")
+	}
+	if _, exists := s.tickets[valid]; !exists {
+		t.Error("new ticket not kept")
+	}
+}
@@ -0,0 +1,261 @@
+package collab
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"codecollab/models"
+	"codecollab/store"
+)
+
+// Permission is something a role allows a member to do with a document
+type Permission int
+
+const (
+	PermView Permission = iota
+	PermComment
+	PermEdit
+	PermShare
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermView:
+		return "view"
+	case PermComment:
+		return "comment on"
+	case PermEdit:
+		return "edit"
+	default:
+		return "change sharing of"
+	}
+}
+
+// roleRank orders the roles by what they allow; a role has every
+// permission up to its rank
+var roleRank = map[models.Role]Permission{
+	models.RoleViewer:    PermView,
+	models.RoleCommenter: PermComment,
+	models.RoleEditor:    PermEdit,
+	models.RoleOwner:     PermShare,
+}
+
+// ErrForbidden matches every PermissionError
+var ErrForbidden = errors.New("forbidden")
+
+// PermissionError is returned when a user's role does not allow an action.
+// Role is empty when the user has no access to the document at all.
+type PermissionError struct {
+	DocumentID string
+	Role       models.Role
+	Permission Permission
+}
+
+func (e *PermissionError) Error() string {
+	if e.Role == "" {
+		return fmt.Sprintf("no access to document %s", e.DocumentID)
+	}
+	return fmt.Sprintf("%s role cannot %s document %s", e.Role, e.Permission, e.DocumentID)
+}
+
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// ValidSharedRole reports whether the role can be given to collaborators
+// and share links; ownership cannot be shared
+func ValidSharedRole(role models.Role) bool {
+	return role == models.RoleEditor || role == models.RoleCommenter || role == models.RoleViewer
+}
+
+// access is who may use a document
+type access struct {
+	ownerID       string
+	collaborators map[string]models.Role
+	links         []models.ShareLink
+}
+
+func documentAccess(doc *store.Document) access {
+	return access{
+		ownerID:       doc.OwnerID,
+		collaborators: maps.Clone(doc.Collaborators),
+		links:         slices.Clone(doc.ShareLinks),
+	}
+}
+
+// role returns the best role the user has, directly or through a valid
+// share link token, or "" for no access
+func (a *access) role(userID, shareToken string, now time.Time) models.Role {
+	if userID == a.ownerID {
+		return models.RoleOwner
+	}
+
+	role := a.collaborators[userID]
+	if shareToken == "" {
+		return role
+	}
+	for _, link := range a.links {
+		if link.Token == shareToken && now.Before(link.ExpiresAt) {
+			if role == "" || roleRank[link.Role] > roleRank[role] {
+				role = link.Role
+			}
+			break
+		}
+	}
+	return role
+}
+
+func (a *access) authorize(documentID, userID, shareToken string, p Permission) (models.Role, error) {
+	role := a.role(userID, shareToken, time.Now())
+	if role == "" || roleRank[role] < p {
+		return role, &PermissionError{DocumentID: documentID, Role: role, Permission: p}
+	}
+	return role, nil
+}
+
+// Authorize checks that the member's role allows the action
+func (r *Room) Authorize(m Member, p Permission) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var shareToken string
+	if state, exists := r.members[m]; exists {
+		shareToken = state.shareToken
+	}
+
+	_, err := r.access.authorize(r.ID, m.Participant().UserID, shareToken, p)
+	return err
+}
+
+// Share gives a user a role in the document, or takes their role away when
+// role is empty, removing their sessions left without access
+func (r *Room) Share(userID string, role models.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if role != "" && !ValidSharedRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+	if userID == "" || userID == r.access.ownerID {
+		return fmt.Errorf("cannot change the role of user %q", userID)
+	}
+
+	if role == "" {
+		delete(r.access.collaborators, userID)
+	} else {
+		if r.access.collaborators == nil {
+			r.access.collaborators = make(map[string]models.Role)
+		}
+		r.access.collaborators[userID] = role
+	}
+	r.accessVersion++
+	r.evictForbidden()
+
+	return nil
+}
+
+// CreateShareLink adds a link granting the role until it expires
+func (r *Room) CreateShareLink(createdBy string, role models.Role, expiresAt time.Time) (models.ShareLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !ValidSharedRole(role) {
+		return models.ShareLink{}, fmt.Errorf("invalid role: %s", role)
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+
+	now := time.Now()
+	link := models.ShareLink{
+		Token:     hex.EncodeToString(token),
+		Role:      role,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	r.access.links = append(r.pruneLinks(now), link)
+	r.accessVersion++
+
+	return link, nil
+}
+
+// RevokeShareLink removes a share link; members who joined with it lose
+// the access it gave them, and are removed if they have no other
+func (r *Room) RevokeShareLink(token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, link := range r.access.links {
+		if link.Token == token {
+			r.access.links = append(r.access.links[:i:i], r.access.links[i+1:]...)
+			r.accessVersion++
+			r.evictForbidden()
+			return nil
+		}
+	}
+	return errors.New("share link not found")
+}
+
+// Sharing describes who has access to the document
+func (r *Room) Sharing() models.SharingMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	collaborators := make(map[string]models.Role, len(r.access.collaborators))
+	for userID, role := range r.access.collaborators {
+		collaborators[userID] = role
+	}
+
+	links := append([]models.ShareLink{}, r.pruneLinks(time.Now())...)
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+
+	return models.SharingMessage{
+		Type:          "sharing",
+		DocumentID:    r.ID,
+		OwnerID:       r.access.ownerID,
+		Collaborators: collaborators,
+		Links:         links,
+	}
+}
+
+// pruneLinks must be called with the lock held. It drops expired links and
+// returns the rest.
+func (r *Room) pruneLinks(now time.Time) []models.ShareLink {
+	valid := r.access.links[:0]
+	for _, link := range r.access.links {
+		if now.Before(link.ExpiresAt) {
+			valid = append(valid, link)
+		}
+	}
+	if len(valid) != len(r.access.links) {
+		r.access.links = valid
+		r.accessVersion++
+		r.evictForbidden()
+	}
+	return r.access.links
+}
+
+// evictForbidden must be called with the lock held. It removes the members
+// who may no longer view the document, so that they stop receiving its
+// edits as soon as their role or share link is taken away.
+func (r *Room) evictForbidden() {
+	now := time.Now()
+	for member, state := range r.members {
+		participant := member.Participant()
+		if r.access.role(participant.UserID, state.shareToken, now) != "" {
+			continue
+		}
+
+		r.remove(member, state)
+		member.Evicted(r.ID, &PermissionError{DocumentID: r.ID, Permission: PermView})
+	}
+}
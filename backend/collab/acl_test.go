@@ -0,0 +1,72 @@
+package collab
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"codecollab/models"
+	"codecollab/store"
+)
+
+func TestLosingAccessEvictsMembers(t *testing.T) {
+	room := newRoom(&store.Document{
+		ID:            "doc",
+		OwnerID:       "owner",
+		Collaborators: map[string]models.Role{"editor": models.RoleEditor},
+		ShareLinks:    []models.ShareLink{{Token: "link", Role: models.RoleViewer, ExpiresAt: time.Now().Add(time.Hour)}},
+	}, 0, nil)
+
+	owner := &simulatedMember{sessionID: "owner"}
+	editor := &simulatedMember{sessionID: "editor"}
+	guest := &simulatedMember{sessionID: "guest"}
+	for _, join := range []struct {
+		member     *simulatedMember
+		shareToken string
+	}{{owner, ""}, {editor, ""}, {guest, "link"}} {
+		if err := room.join(join.member, "", join.shareToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A member who can still view the document stays
+	if err := room.Share("editor", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if !room.Has(editor) || editor.evicted != nil {
+		t.Fatal("member downgraded to viewer was removed")
+	}
+
+	if err := room.Share("editor", ""); err != nil {
+		t.Fatal(err)
+	}
+	if room.Has(editor) || !errors.Is(editor.evicted, ErrForbidden) {
+		t.Fatalf("member whose role was removed: in room = %v, told %v", room.Has(editor), editor.evicted)
+	}
+
+	if err := room.RevokeShareLink("link"); err != nil {
+		t.Fatal(err)
+	}
+	if room.Has(guest) || !errors.Is(guest.evicted, ErrForbidden) {
+		t.Fatalf("member whose share link was revoked: in room = %v, told %v", room.Has(guest), guest.evicted)
+	}
+
+	// Removed members see nothing more of the document
+	received := len(editor.inbox) + len(guest.inbox)
+	if _, err := room.ApplyEdit(owner, "", 0, []models.TextOp{{Insert: "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(editor.inbox)+len(guest.inbox) != received {
+		t.Error("removed members received an edit")
+	}
+
+	var left []string
+	for _, message := range owner.inbox {
+		if m, ok := message.(models.DocumentMessage); ok && m.Type == "participant_left" {
+			left = append(left, m.SessionID)
+		}
+	}
+	if len(left) != 2 || left[0] != "editor" || left[1] != "guest" {
+		t.Errorf("owner saw %v leave, want [editor guest]", left)
+	}
+}
@@ -0,0 +1,183 @@
+package collab
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"codecollab/models"
+)
+
+const (
+	// MaxCommentLength is the longest comment body, in characters
+	MaxCommentLength = 10000
+
+	maxThreads        = 1000
+	maxThreadComments = 500
+)
+
+var (
+	ErrThreadNotFound = errors.New("comment thread not found")
+	ErrInvalidComment = errors.New("invalid comment")
+)
+
+// AddThread starts a comment thread on a range of the document
+func (r *Room) AddThread(m Member, requestID string, anchor models.Range, body string) (*models.CommentThread, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	comment, err := newComment(m, body)
+	if err != nil {
+		return nil, err
+	}
+
+	length := utf8.RuneCountInString(r.content)
+	if anchor.From < 0 || anchor.From > anchor.To || anchor.To > length {
+		return nil, fmt.Errorf("%w: range must be within the document", ErrInvalidComment)
+	}
+	if len(r.threads) >= maxThreads {
+		return nil, fmt.Errorf("%w: document has too many threads", ErrInvalidComment)
+	}
+
+	thread := &models.CommentThread{
+		ID:        newID(),
+		Range:     anchor,
+		Comments:  []models.Comment{comment},
+		CreatedAt: comment.CreatedAt,
+		UpdatedAt: comment.CreatedAt,
+	}
+	r.threads = append(r.threads, thread)
+
+	return r.threadChanged(m, requestID, thread), nil
+}
+
+// Reply adds a comment to a thread, reopening it if it was resolved
+func (r *Room) Reply(m Member, requestID, threadID, body string) (*models.CommentThread, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	thread := r.thread(threadID)
+	if thread == nil {
+		return nil, ErrThreadNotFound
+	}
+
+	comment, err := newComment(m, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(thread.Comments) >= maxThreadComments {
+		return nil, fmt.Errorf("%w: thread has too many comments", ErrInvalidComment)
+	}
+
+	thread.Comments = append(thread.Comments, comment)
+	thread.Resolved = false
+	thread.ResolvedBy = ""
+	thread.UpdatedAt = comment.CreatedAt
+
+	return r.threadChanged(m, requestID, thread), nil
+}
+
+// Resolve marks a thread resolved, or reopens it
+func (r *Room) Resolve(m Member, requestID, threadID string, resolved bool) (*models.CommentThread, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	thread := r.thread(threadID)
+	if thread == nil {
+		return nil, ErrThreadNotFound
+	}
+
+	thread.Resolved = resolved
+	thread.ResolvedBy = ""
+	if resolved {
+		thread.ResolvedBy = m.Participant().UserID
+	}
+	thread.UpdatedAt = time.Now()
+
+	return r.threadChanged(m, requestID, thread), nil
+}
+
+// threadChanged must be called with the lock held. It marks the threads for
+// saving and sends the thread to every member, the member who changed it
+// answering the request with requestID, returning the copy sent.
+func (r *Room) threadChanged(m Member, requestID string, thread *models.CommentThread) *models.CommentThread {
+	r.threadsVersion++
+
+	sent := *thread
+	participant := m.Participant()
+	message := models.DocumentMessage{
+		Type:       "thread",
+		DocumentID: r.ID,
+		UserID:     participant.UserID,
+		SessionID:  participant.SessionID,
+		Thread:     &sent,
+	}
+	r.broadcast(message, m)
+
+	message.ID = requestID
+	m.Send(message)
+
+	return &sent
+}
+
+func (r *Room) thread(id string) *models.CommentThread {
+	for _, thread := range r.threads {
+		if thread.ID == id {
+			return thread
+		}
+	}
+	return nil
+}
+
+// transformThreads moves every thread's range through an applied edit. A
+// range whose text is deleted collapses to where the text was.
+func (r *Room) transformThreads(ops []models.TextOp) {
+	if len(r.threads) == 0 {
+		return
+	}
+
+	for _, thread := range r.threads {
+		from := TransformPosition(thread.Range.From, ops)
+		to := TransformPosition(thread.Range.To, ops)
+		thread.Range = models.Range{From: from, To: max(from, to)}
+	}
+	r.threadsVersion++
+}
+
+// commentThreads returns copies of the threads, oldest first
+func (r *Room) commentThreads() []models.CommentThread {
+	threads := make([]models.CommentThread, len(r.threads))
+	for i, thread := range r.threads {
+		threads[i] = *thread
+	}
+	return threads
+}
+
+func newComment(m Member, body string) (models.Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return models.Comment{}, fmt.Errorf("%w: body is empty", ErrInvalidComment)
+	}
+	if utf8.RuneCountInString(body) > MaxCommentLength {
+		return models.Comment{}, fmt.Errorf("%w: body exceeds %d characters", ErrInvalidComment, MaxCommentLength)
+	}
+
+	participant := m.Participant()
+	return models.Comment{
+		ID:         newID(),
+		AuthorID:   participant.UserID,
+		AuthorName: participant.DisplayName,
+		Body:       body,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,252 @@
+package collab
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"codecollab/models"
+)
+
+const (
+	// maxDiffEdits bounds the work spent finding a minimal line diff; larger
+	// changes are reported as replacing everything between the common
+	// leading and trailing lines
+	maxDiffEdits = 1000
+
+	diffContext = 3
+)
+
+// lineEdit is one line of a diff: kept (' '), removed ('-') or added ('+')
+type lineEdit struct {
+	kind byte
+	line string
+}
+
+// DiffOps returns an edit that turns text a into text b, changing whole lines
+func DiffOps(a, b string) []models.TextOp {
+	var ops []models.TextOp
+	push := func(op models.TextOp) {
+		if n := len(ops); n > 0 {
+			last := &ops[n-1]
+			switch {
+			case op.Retain > 0 && last.Retain > 0:
+				last.Retain += op.Retain
+				return
+			case op.Delete > 0 && last.Delete > 0:
+				last.Delete += op.Delete
+				return
+			case op.Insert != "" && last.Insert != "":
+				last.Insert += op.Insert
+				return
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	for _, edit := range diffLines(splitLines(a), splitLines(b)) {
+		switch edit.kind {
+		case ' ':
+			push(models.TextOp{Retain: utf8.RuneCountInString(edit.line)})
+		case '-':
+			push(models.TextOp{Delete: utf8.RuneCountInString(edit.line)})
+		case '+':
+			push(models.TextOp{Insert: edit.line})
+		}
+	}
+
+	if n := len(ops); n > 0 && ops[n-1].Retain > 0 {
+		ops = ops[:n-1]
+	}
+	return ops
+}
+
+// UnifiedDiff formats the line differences between a and b as a unified diff
+func UnifiedDiff(a, b, fromName, toName string) string {
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for start := 0; start < len(edits); {
+		// Find the next change and extend the hunk while changes are
+		// within twice the context of each other
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		end := first
+		for i := first; i < len(edits); i++ {
+			if edits[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		hunkStart := max(first-diffContext, start)
+		hunkEnd := min(end+diffContext, len(edits))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&out, edits, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []lineEdit, from, to int) {
+	aStart, bStart := 1, 1
+	for _, edit := range edits[:from] {
+		if edit.kind != '+' {
+			aStart++
+		}
+		if edit.kind != '-' {
+			bStart++
+		}
+	}
+
+	aLines, bLines := 0, 0
+	for _, edit := range edits[from:to] {
+		if edit.kind != '+' {
+			aLines++
+		}
+		if edit.kind != '-' {
+			bLines++
+		}
+	}
+	// An empty range starts at the line before it, as in diff -u
+	if aLines == 0 {
+		aStart--
+	}
+	if bLines == 0 {
+		bStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aLines, bStart, bLines)
+	for _, edit := range edits[from:to] {
+		out.WriteByte(edit.kind)
+		out.WriteString(edit.line)
+		if !strings.HasSuffix(edit.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits text after each newline, keeping the newlines
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines finds a shortest line edit script from a to b with Myers' algorithm
+func diffLines(a, b []string) []lineEdit {
+	var prefix, suffix []lineEdit
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, lineEdit{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, lineEdit{' ', a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	edits := append(prefix, myers(a, b)...)
+	for i := len(suffix) - 1; i >= 0; i-- {
+		edits = append(edits, suffix[i])
+	}
+	return edits
+}
+
+func myers(a, b []string) []lineEdit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k; trace[d] keeps
+	// diagonals -d..d after d edits for walking the path back
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(a, b, trace)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	edits := make([]lineEdit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, lineEdit{'-', line})
+	}
+	for _, line := range b {
+		edits = append(edits, lineEdit{'+', line})
+	}
+	return edits
+}
+
+func backtrack(a, b []string, trace [][]int) []lineEdit {
+	x, y := len(a), len(b)
+	var reversed []lineEdit
+
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, lineEdit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, lineEdit{'+', b[prevY]})
+		} else {
+			reversed = append(reversed, lineEdit{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, lineEdit{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	edits := make([]lineEdit, len(reversed))
+	for i, edit := range reversed {
+		edits[len(reversed)-1-i] = edit
+	}
+	return edits
+}
@@ -0,0 +1,141 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"codecollab/models"
+	"codecollab/store"
+)
+
+// ErrUnknownRevision is returned for revisions a document's history does not have
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// Revisions returns up to limit revisions of the document from the given
+// one onwards, along with its current revision
+func (h *Hub) Revisions(ctx context.Context, documentID string, from, limit int) ([]models.Revision, int, error) {
+	doc, err := h.stored(ctx, documentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	to := min(from+limit-1, doc.Revision)
+	revisions, err := h.store.ListRevisions(ctx, documentID, from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	return revisions, doc.Revision, nil
+}
+
+// ContentAt rebuilds the document text at a revision from the latest
+// snapshot before it and the revisions since
+func (h *Hub) ContentAt(ctx context.Context, documentID string, revision int) (string, error) {
+	doc, err := h.stored(ctx, documentID)
+	if err != nil {
+		return "", err
+	}
+	if revision < 0 || revision > doc.Revision {
+		return "", fmt.Errorf("%w: %d", ErrUnknownRevision, revision)
+	}
+
+	snapshot, err := h.store.GetSnapshot(ctx, documentID, revision)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", fmt.Errorf("%w: history before revision %d was not kept", ErrUnknownRevision, revision)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	revisions, err := h.store.ListRevisions(ctx, documentID, snapshot.Revision+1, revision)
+	if err != nil {
+		return "", err
+	}
+
+	content := snapshot.Content
+	for i, applied := range revisions {
+		if applied.Revision != snapshot.Revision+1+i {
+			break
+		}
+		if content, err = Apply(content, applied.Ops); err != nil {
+			return "", fmt.Errorf("failed to replay revision %d: %w", applied.Revision, err)
+		}
+	}
+	if len(revisions) != revision-snapshot.Revision {
+		return "", fmt.Errorf("%w: history of revision %d is incomplete", ErrUnknownRevision, revision)
+	}
+
+	return content, nil
+}
+
+// Diff returns the edit turning the document at one revision into the
+// document at another, and the same change as a unified diff
+func (h *Hub) Diff(ctx context.Context, documentID string, from, to int) ([]models.TextOp, string, error) {
+	a, err := h.ContentAt(ctx, documentID, from)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := h.ContentAt(ctx, documentID, to)
+	if err != nil {
+		return nil, "", err
+	}
+
+	diff := UnifiedDiff(a, b, fmt.Sprintf("%s@%d", documentID, from), fmt.Sprintf("%s@%d", documentID, to))
+	return DiffOps(a, b), diff, nil
+}
+
+// Restore sets the document back to its text at an earlier revision. The
+// change is made as a new revision by the user, so it reaches anyone
+// editing the document as an ordinary edit and can itself be undone.
+func (h *Hub) Restore(ctx context.Context, documentID string, revision int, userID string) (int, error) {
+	content, err := h.ContentAt(ctx, documentID, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, exists := h.rooms[documentID]
+	if !exists {
+		if room, err = h.open(documentID, "", "", ""); err != nil {
+			return 0, err
+		}
+	}
+
+	restored, err := room.Restore(content, userID)
+	if room.empty() && h.rooms[room.ID] == room {
+		h.close(room)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Info("User %s restored document %s to revision %d as revision %d", userID, documentID, revision, restored)
+	return restored, nil
+}
+
+// RecordLint keeps the analysis of a revision for replaying the document's history
+func (h *Hub) RecordLint(ctx context.Context, documentID string, result *models.LintResult) error {
+	return h.store.SaveLintResult(ctx, documentID, result)
+}
+
+// LintResults returns the recorded analyses of revisions from..to of the document
+func (h *Hub) LintResults(ctx context.Context, documentID string, from, to int) ([]models.LintResult, error) {
+	return h.store.ListLintResults(ctx, documentID, from, to)
+}
+
+// stored saves the document if its room is open and returns it as stored
+func (h *Hub) stored(ctx context.Context, documentID string) (*store.Document, error) {
+	h.mu.Lock()
+	room, exists := h.rooms[documentID]
+	h.mu.Unlock()
+
+	if exists {
+		if err := h.flush(room, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.store.GetDocument(ctx, documentID)
+}
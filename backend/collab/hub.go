@@ -0,0 +1,275 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"codecollab/store"
+	"codecollab/utils"
+)
+
+var logger = utils.NewLogger("collab")
+
+// Hub tracks the open document rooms. A room is loaded from the store when
+// its first member joins, and saved and discarded when the last one leaves.
+type Hub struct {
+	rooms map[string]*Room
+	store store.DocumentStore
+	mu    sync.Mutex
+
+	// snapshotInterval is how many revisions a document goes between full
+	// snapshots in its history
+	snapshotInterval int
+}
+
+func NewHub(documents store.DocumentStore, snapshotInterval int) *Hub {
+	return &Hub{
+		rooms:            make(map[string]*Room),
+		store:            documents,
+		snapshotInterval: snapshotInterval,
+	}
+}
+
+// Join adds the member to the document's room if it may view the document,
+// directly or through the share link token. A document that has never been
+// saved is created with the given language and content, owned by the member
+// joining it.
+func (h *Hub) Join(requestID, documentID, language, content, shareToken string, m Member) (*Room, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room, exists := h.rooms[documentID]
+	if !exists {
+		var err error
+		if room, err = h.open(documentID, language, content, m.Participant().UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := room.join(m, requestID, shareToken); err != nil {
+		if room.empty() {
+			h.close(room)
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+// Authorize checks that the user's role in the document, directly or
+// through the share link token, allows the action
+func (h *Hub) Authorize(ctx context.Context, documentID, userID, shareToken string, p Permission) error {
+	h.mu.Lock()
+	room, exists := h.rooms[documentID]
+	h.mu.Unlock()
+
+	if exists {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+
+		_, err := room.access.authorize(documentID, userID, shareToken, p)
+		return err
+	}
+
+	doc, err := h.store.GetDocument(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	access := documentAccess(doc)
+	_, err = access.authorize(documentID, userID, shareToken, p)
+	return err
+}
+
+// open must be called with the hub lock held
+func (h *Hub) open(documentID, language, content, ownerID string) (*Room, error) {
+	ctx := context.Background()
+
+	doc, err := h.store.GetDocument(ctx, documentID)
+	if errors.Is(err, store.ErrNotFound) {
+		now := time.Now()
+		doc = &store.Document{
+			ID:        documentID,
+			Language:  language,
+			Content:   content,
+			OwnerID:   ownerID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		err = h.store.SaveDocument(ctx, doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every document's history starts from a snapshot, including documents
+	// saved before history was kept
+	snapshot, err := h.store.GetSnapshot(ctx, documentID, doc.Revision)
+	if errors.Is(err, store.ErrNotFound) {
+		snapshot = &store.Snapshot{
+			Revision:  doc.Revision,
+			Content:   doc.Content,
+			CreatedAt: doc.UpdatedAt,
+		}
+		err = h.store.SaveSnapshot(ctx, documentID, snapshot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := h.store.GetThreads(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	room := newRoom(doc, snapshot.Revision, threads)
+	h.rooms[documentID] = room
+	logger.Info("Opened room for document: %s", documentID)
+
+	return room, nil
+}
+
+// Leave removes the member from the room, saving and closing the room once
+// it is empty
+func (h *Hub) Leave(room *Room, m Member) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if room.leave(m) && h.rooms[room.ID] == room {
+		h.close(room)
+	}
+}
+
+// close must be called with the hub lock held. A room that fails to save
+// stays open so the periodic flush can retry.
+func (h *Hub) close(room *Room) {
+	if err := h.flush(room, true); err != nil {
+		logger.Warn("Keeping document %s open until it is saved", room.ID)
+		return
+	}
+
+	delete(h.rooms, room.ID)
+	logger.Info("Closed room for document: %s", room.ID)
+}
+
+// FlushPeriodically saves rooms with unsaved edits at the given interval.
+// It blocks, so run it in a goroutine.
+func (h *Hub) FlushPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.FlushAll()
+	}
+}
+
+// FlushAll saves every open room with unsaved edits, closing rooms left
+// open by an earlier failed save once nobody is in them
+func (h *Hub) FlushAll() {
+	for _, room := range h.openRooms() {
+		if h.flush(room, false) != nil {
+			continue
+		}
+
+		h.mu.Lock()
+		if room.empty() && h.rooms[room.ID] == room {
+			h.close(room)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// flush saves the room if it has changed. Closing marks the room so that no
+// later flush of it can overwrite what the document is saved as once reopened.
+func (h *Hub) flush(room *Room, closing bool) error {
+	room.saveMu.Lock()
+	defer room.saveMu.Unlock()
+
+	if room.closed {
+		return nil
+	}
+
+	state, changed := room.unsaved()
+	if changed {
+		snapshot := state.doc.Revision-room.snapshotRevision >= h.snapshotInterval
+		if err := h.save(state, snapshot); err != nil {
+			logger.Error("Failed to save document %s: %v", room.ID, err)
+			return err
+		}
+		room.saved(state, snapshot)
+	}
+
+	room.closed = closing
+	return nil
+}
+
+// save appends the revisions to the document's log and saves its threads
+// before the document itself, so the saved revision is always covered by
+// the log
+func (h *Hub) save(state *roomState, snapshot bool) error {
+	ctx := context.Background()
+	doc := state.doc
+
+	if len(state.revisions) > 0 {
+		if err := h.store.AppendRevisions(ctx, doc.ID, state.revisions); err != nil {
+			return err
+		}
+	}
+
+	if state.threads != nil {
+		if err := h.store.SaveThreads(ctx, doc.ID, state.threads); err != nil {
+			return err
+		}
+	}
+
+	if snapshot {
+		err := h.store.SaveSnapshot(ctx, doc.ID, &store.Snapshot{
+			Revision:  doc.Revision,
+			Content:   doc.Content,
+			CreatedAt: doc.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.store.SaveDocument(ctx, doc)
+}
+
+func (h *Hub) openRooms() []*Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	open := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		open = append(open, room)
+	}
+	return open
+}
+
+// Count returns the number of open rooms
+func (h *Hub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.rooms)
+}
+
+// ExpirePresence periodically hides the cursors of members that have been
+// idle for longer than the timeout. It blocks, so run it in a goroutine.
+func (h *Hub) ExpirePresence(idleTimeout time.Duration) {
+	interval := idleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleTimeout)
+		for _, room := range h.openRooms() {
+			room.expirePresence(cutoff)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package collab
+
+import (
+	"errors"
+	"fmt"
+
+	"codecollab/models"
+)
+
+// MaxDocumentSize is the largest document, in characters, a room will hold
+const MaxDocumentSize = 1024 * 1024
+
+var ErrInvalidOp = errors.New("invalid edit operation")
+
+// Apply applies an edit to the text. Ops are read from the start of the
+// document; anything after the last op is retained unchanged.
+func Apply(text string, ops []models.TextOp) (string, error) {
+	if err := Validate(ops); err != nil {
+		return "", err
+	}
+
+	runes := []rune(text)
+	result := make([]rune, 0, len(runes))
+	pos := 0
+
+	for _, op := range ops {
+		switch {
+		case op.Retain > 0:
+			if pos+op.Retain > len(runes) {
+				return "", fmt.Errorf("%w: retain past end of document", ErrInvalidOp)
+			}
+			result = append(result, runes[pos:pos+op.Retain]...)
+			pos += op.Retain
+		case op.Delete > 0:
+			if pos+op.Delete > len(runes) {
+				return "", fmt.Errorf("%w: delete past end of document", ErrInvalidOp)
+			}
+			pos += op.Delete
+		default:
+			result = append(result, []rune(op.Insert)...)
+		}
+	}
+	result = append(result, runes[pos:]...)
+
+	if len(result) > MaxDocumentSize {
+		return "", fmt.Errorf("%w: document exceeds %d characters", ErrInvalidOp, MaxDocumentSize)
+	}
+
+	return string(result), nil
+}
+
+// Validate checks that every op sets exactly one of retain, insert or delete.
+// An empty edit is valid and leaves the document unchanged.
+func Validate(ops []models.TextOp) error {
+	for i, op := range ops {
+		set := 0
+		if op.Retain != 0 {
+			set++
+		}
+		if op.Insert != "" {
+			set++
+		}
+		if op.Delete != 0 {
+			set++
+		}
+
+		if set != 1 || op.Retain < 0 || op.Delete < 0 {
+			return fmt.Errorf("%w: op %d must have exactly one positive retain, delete or non-empty insert", ErrInvalidOp, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package collab
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"codecollab/models"
+)
+
+// BaseLength is the length of the document an edit applies to, assuming it
+// spans the whole document
+func BaseLength(ops []models.TextOp) int {
+	n := 0
+	for _, op := range ops {
+		n += op.Retain + op.Delete
+	}
+	return n
+}
+
+// TargetLength is the length of the document after the edit is applied
+func TargetLength(ops []models.TextOp) int {
+	n := 0
+	for _, op := range ops {
+		n += op.Retain + utf8.RuneCountInString(op.Insert)
+	}
+	return n
+}
+
+// Normalize extends the edit with a trailing retain so that it spans a
+// document of the given length, and merges adjacent ops of the same kind
+func Normalize(ops []models.TextOp, length int) ([]models.TextOp, error) {
+	if err := Validate(ops); err != nil {
+		return nil, err
+	}
+
+	base := BaseLength(ops)
+	if base > length {
+		return nil, fmt.Errorf("%w: edit spans %d characters but the document has %d", ErrInvalidOp, base, length)
+	}
+
+	b := &opBuilder{}
+	for _, op := range ops {
+		b.add(op)
+	}
+	b.retain(length - base)
+
+	return b.ops, nil
+}
+
+// Transform takes two edits made concurrently to the same document and
+// returns a' and b' such that applying a then b' gives the same text as
+// applying b then a'. When both insert at the same position, a's text is
+// placed first. Both edits must span the whole document.
+func Transform(a, b []models.TextOp) ([]models.TextOp, []models.TextOp, error) {
+	if BaseLength(a) != BaseLength(b) {
+		return nil, nil, fmt.Errorf("%w: concurrent edits have different base lengths", ErrInvalidOp)
+	}
+
+	aPrime, bPrime := &opBuilder{}, &opBuilder{}
+	ra, rb := newOpReader(a), newOpReader(b)
+
+	for !ra.done || !rb.done {
+		if !ra.done && ra.op.Insert != "" {
+			aPrime.add(ra.op)
+			bPrime.retain(utf8.RuneCountInString(ra.op.Insert))
+			ra.advance()
+			continue
+		}
+		if !rb.done && rb.op.Insert != "" {
+			aPrime.retain(utf8.RuneCountInString(rb.op.Insert))
+			bPrime.add(rb.op)
+			rb.advance()
+			continue
+		}
+		if ra.done || rb.done {
+			return nil, nil, fmt.Errorf("%w: concurrent edits have different lengths", ErrInvalidOp)
+		}
+
+		n := min(ra.op.Retain+ra.op.Delete, rb.op.Retain+rb.op.Delete)
+		switch {
+		case ra.op.Retain > 0 && rb.op.Retain > 0:
+			aPrime.retain(n)
+			bPrime.retain(n)
+		case ra.op.Delete > 0 && rb.op.Retain > 0:
+			aPrime.delete(n)
+		case ra.op.Retain > 0 && rb.op.Delete > 0:
+			bPrime.delete(n)
+		default:
+			// Both deleted the same text, so neither transformed edit deletes it again
+		}
+
+		ra.take(n)
+		rb.take(n)
+	}
+
+	return aPrime.ops, bPrime.ops, nil
+}
+
+// opReader walks the ops of an edit, splitting retains and deletes as they
+// are partly consumed
+type opReader struct {
+	ops   []models.TextOp
+	index int
+	op    models.TextOp
+	done  bool
+}
+
+func newOpReader(ops []models.TextOp) *opReader {
+	r := &opReader{ops: ops}
+	r.advance()
+	return r
+}
+
+func (r *opReader) advance() {
+	if r.index >= len(r.ops) {
+		r.done = true
+		return
+	}
+	r.op = r.ops[r.index]
+	r.index++
+}
+
+// take consumes n characters of the current retain or delete
+func (r *opReader) take(n int) {
+	if r.op.Retain > 0 {
+		r.op.Retain -= n
+	} else {
+		r.op.Delete -= n
+	}
+	if r.op.Retain == 0 && r.op.Delete == 0 {
+		r.advance()
+	}
+}
+
+// opBuilder appends ops, merging consecutive ops of the same kind
+type opBuilder struct {
+	ops []models.TextOp
+}
+
+func (b *opBuilder) add(op models.TextOp) {
+	switch {
+	case op.Retain > 0:
+		b.retain(op.Retain)
+	case op.Delete > 0:
+		b.delete(op.Delete)
+	case op.Insert != "":
+		b.insert(op.Insert)
+	}
+}
+
+func (b *opBuilder) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := b.last(); last != nil && last.Retain > 0 {
+		last.Retain += n
+		return
+	}
+	b.ops = append(b.ops, models.TextOp{Retain: n})
+}
+
+func (b *opBuilder) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := b.last(); last != nil && last.Delete > 0 {
+		last.Delete += n
+		return
+	}
+	b.ops = append(b.ops, models.TextOp{Delete: n})
+}
+
+// insert keeps inserts ahead of a delete at the same position so that
+// equivalent edits have a single representation
+func (b *opBuilder) insert(text string) {
+	last := b.last()
+	switch {
+	case last != nil && last.Insert != "":
+		last.Insert += text
+	case last != nil && last.Delete > 0:
+		if len(b.ops) > 1 && b.ops[len(b.ops)-2].Insert != "" {
+			b.ops[len(b.ops)-2].Insert += text
+			return
+		}
+		b.ops = append(b.ops, *last)
+		b.ops[len(b.ops)-2] = models.TextOp{Insert: text}
+	default:
+		b.ops = append(b.ops, models.TextOp{Insert: text})
+	}
+}
+
+func (b *opBuilder) last() *models.TextOp {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	return &b.ops[len(b.ops)-1]
+}
+
+// TransformPosition moves a character offset through an edit to where the
+// same text is afterwards. Text inserted at the position goes before it.
+func TransformPosition(position int, ops []models.TextOp) int {
+	pos, result := 0, position
+	for _, op := range ops {
+		if pos > position {
+			break
+		}
+		switch {
+		case op.Retain > 0:
+			pos += op.Retain
+		case op.Delete > 0:
+			result -= min(op.Delete, position-pos)
+			pos += op.Delete
+		default:
+			result += utf8.RuneCountInString(op.Insert)
+		}
+	}
+	return result
+}
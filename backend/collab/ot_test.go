@@ -0,0 +1,270 @@
+package collab
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"codecollab/models"
+	"codecollab/store"
+)
+
+// alphabet mixes ASCII with multi-byte characters, since edits count code
+// points rather than bytes
+var alphabet = []rune("abcxyz \néß世界🙂")
+
+func randomText(rng *rand.Rand, maxLength int) string {
+	var b strings.Builder
+	for range rng.Intn(maxLength + 1) {
+		b.WriteRune(alphabet[rng.Intn(len(alphabet))])
+	}
+	return b.String()
+}
+
+// randomEdit returns a normalized edit of a document of the given length
+func randomEdit(t *testing.T, rng *rand.Rand, length int) []models.TextOp {
+	var ops []models.TextOp
+	for pos := 0; pos < length; {
+		n := 1 + rng.Intn(min(length-pos, 5))
+		switch rng.Intn(4) {
+		case 0:
+			ops = append(ops, models.TextOp{Delete: n})
+			pos += n
+		case 1:
+			ops = append(ops, models.TextOp{Insert: randomText(rng, 3) + "+"})
+		default:
+			ops = append(ops, models.TextOp{Retain: n})
+			pos += n
+		}
+	}
+	if rng.Intn(2) == 0 {
+		ops = append(ops, models.TextOp{Insert: randomText(rng, 3) + "+"})
+	}
+
+	ops, err := Normalize(ops, length)
+	if err != nil {
+		t.Fatalf("random edit is invalid: %v", err)
+	}
+	return ops
+}
+
+func mustApply(t *testing.T, text string, ops []models.TextOp) string {
+	t.Helper()
+	result, err := Apply(text, ops)
+	if err != nil {
+		t.Fatalf("Apply(%q, %v): %v", text, ops, err)
+	}
+	return result
+}
+
+func TestTransformConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		doc := randomText(rng, 20)
+		length := utf8.RuneCountInString(doc)
+		a, b := randomEdit(t, rng, length), randomEdit(t, rng, length)
+
+		aPrime, bPrime, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%v, %v): %v", a, b, err)
+		}
+
+		ab := mustApply(t, mustApply(t, doc, a), bPrime)
+		ba := mustApply(t, mustApply(t, doc, b), aPrime)
+		if ab != ba {
+			t.Fatalf("edits of %q diverge:\na = %v, b' = %v gives %q\nb = %v, a' = %v gives %q", doc, a, bPrime, ab, b, aPrime, ba)
+		}
+	}
+}
+
+func TestTransformPlacesFirstInsertFirst(t *testing.T) {
+	a := []models.TextOp{{Retain: 1}, {Insert: "A"}, {Retain: 1}}
+	b := []models.TextOp{{Retain: 1}, {Insert: "B"}, {Retain: 1}}
+
+	aPrime, bPrime, err := Transform(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustApply(t, mustApply(t, "xy", a), bPrime); got != "xABy" {
+		t.Errorf("a then b' = %q, want %q", got, "xABy")
+	}
+	if got := mustApply(t, mustApply(t, "xy", b), aPrime); got != "xABy" {
+		t.Errorf("b then a' = %q, want %q", got, "xABy")
+	}
+}
+
+// simulatedMember is a client editing a room the way the editor does: it
+// applies its own edits at once, has at most one unacknowledged edit, and
+// transforms the edits of others against it. Messages from the room wait
+// in its inbox until delivered.
+type simulatedMember struct {
+	sessionID string
+
+	mu      sync.Mutex
+	inbox   []interface{}
+	evicted error
+
+	text     string
+	revision int
+	// pending is the edit sent and not yet acknowledged; outbox holds it
+	// until the room receives it
+	pending []models.TextOp
+	outbox  []models.TextOp
+	sentAt  int
+}
+
+func (m *simulatedMember) Participant() models.Participant {
+	return models.Participant{SessionID: m.sessionID, UserID: m.sessionID}
+}
+
+func (m *simulatedMember) LastSeen() time.Time {
+	return time.Now()
+}
+
+func (m *simulatedMember) Send(message interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inbox = append(m.inbox, message)
+	return nil
+}
+
+func (m *simulatedMember) Evicted(documentID string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evicted = err
+}
+
+// edit makes a random local edit if none is pending
+func (m *simulatedMember) edit(t *testing.T, rng *rand.Rand) {
+	if m.pending != nil {
+		return
+	}
+	ops := randomEdit(t, rng, utf8.RuneCountInString(m.text))
+	m.text = mustApply(t, m.text, ops)
+	m.pending, m.outbox, m.sentAt = ops, ops, m.revision
+}
+
+// submit delivers the pending edit to the room
+func (m *simulatedMember) submit(t *testing.T, room *Room) {
+	if m.outbox == nil {
+		return
+	}
+	if _, err := room.ApplyEdit(m, "", m.sentAt, m.outbox); err != nil {
+		t.Fatalf("ApplyEdit at revision %d: %v", m.sentAt, err)
+	}
+	m.outbox = nil
+}
+
+// receive handles the oldest message from the room
+func (m *simulatedMember) receive(t *testing.T) bool {
+	m.mu.Lock()
+	if len(m.inbox) == 0 {
+		m.mu.Unlock()
+		return false
+	}
+	message := m.inbox[0].(models.DocumentMessage)
+	m.inbox = m.inbox[1:]
+	m.mu.Unlock()
+
+	switch message.Type {
+	case "ack":
+		m.pending = nil
+	case "edit":
+		ops := message.Ops
+		if m.pending != nil {
+			var err error
+			// The pending edit reaches the room later, so its text goes first
+			if m.pending, ops, err = Transform(m.pending, ops); err != nil {
+				t.Fatalf("transforming a received edit: %v", err)
+			}
+		}
+		m.text = mustApply(t, m.text, ops)
+	default:
+		t.Fatalf("unexpected message %q", message.Type)
+	}
+	m.revision = message.Revision
+	return true
+}
+
+func newTestRoom(content string) *Room {
+	return newRoom(&store.Document{ID: "doc", Language: "go", Content: content}, 0, nil)
+}
+
+func addTestMember(room *Room, m Member) {
+	room.members[m] = &memberState{}
+}
+
+// textAndRevision returns the room's text and revision
+func textAndRevision(room *Room) (string, int) {
+	_, content, revision := room.Snapshot()
+	return content, revision
+}
+
+func TestRoomEditsConverge(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+
+		doc := randomText(rng, 10)
+		room := newTestRoom(doc)
+
+		members := make([]*simulatedMember, 2+rng.Intn(4))
+		for i := range members {
+			members[i] = &simulatedMember{sessionID: string(rune('a' + i)), text: doc}
+			addTestMember(room, members[i])
+		}
+
+		// Members edit, send and receive in a random order, so edits reach
+		// the room based on revisions that others have moved past
+		for step := 0; step < 300; step++ {
+			m := members[rng.Intn(len(members))]
+			switch rng.Intn(3) {
+			case 0:
+				m.edit(t, rng)
+			case 1:
+				m.submit(t, room)
+			default:
+				m.receive(t)
+			}
+		}
+
+		for _, m := range members {
+			m.submit(t, room)
+		}
+		for _, m := range members {
+			for m.receive(t) {
+			}
+		}
+
+		content, revision := textAndRevision(room)
+		for _, m := range members {
+			if m.text != content || m.revision != revision {
+				t.Fatalf("seed %d: member %s has %q at revision %d, room has %q at revision %d",
+					seed, m.sessionID, m.text, m.revision, content, revision)
+			}
+		}
+	}
+}
+
+func TestRoomPlacesLaterInsertFirst(t *testing.T) {
+	room := newTestRoom("xy")
+	first, second := &simulatedMember{sessionID: "a"}, &simulatedMember{sessionID: "b"}
+	addTestMember(room, first)
+	addTestMember(room, second)
+
+	if _, err := room.ApplyEdit(first, "", 0, []models.TextOp{{Retain: 1}, {Insert: "A"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := room.ApplyEdit(second, "", 0, []models.TextOp{{Retain: 1}, {Insert: "B"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := textAndRevision(room); content != "xBAy" {
+		t.Errorf("content = %q, want %q", content, "xBAy")
+	}
+}
@@ -0,0 +1,137 @@
+package collab
+
+import (
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"codecollab/models"
+)
+
+// presenceInterval is the minimum time between presence broadcasts for a
+// member; updates in between are coalesced and the latest one sent after it
+const presenceInterval = 50 * time.Millisecond
+
+// memberState is what a room tracks for each member
+type memberState struct {
+	selection     *models.Selection
+	lastPresence  time.Time
+	presenceTimer *time.Timer
+
+	// shareToken is the share link the member joined with, if any
+	shareToken string
+}
+
+// UpdatePresence records the member's selection, clamped to the document,
+// and shares it with the other members, at most once per presenceInterval.
+// A nil selection hides the member's cursor. A request with an ID is
+// answered right away with the member's own presence as the room sees it.
+func (r *Room) UpdatePresence(m Member, requestID string, selection *models.Selection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, exists := r.members[m]
+	if !exists {
+		return
+	}
+
+	if selection != nil {
+		length := utf8.RuneCountInString(r.content)
+		selection = &models.Selection{
+			Anchor: max(0, min(selection.Anchor, length)),
+			Head:   max(0, min(selection.Head, length)),
+		}
+	}
+	state.selection = selection
+
+	if requestID != "" {
+		answer := r.presenceMessage(m, state)
+		answer.ID = requestID
+		m.Send(answer)
+	}
+
+	if state.presenceTimer != nil {
+		return
+	}
+
+	if wait := presenceInterval - time.Since(state.lastPresence); wait > 0 {
+		state.presenceTimer = time.AfterFunc(wait, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if current, exists := r.members[m]; exists && current == state {
+				state.presenceTimer = nil
+				r.broadcastPresence(m, state)
+			}
+		})
+		return
+	}
+
+	r.broadcastPresence(m, state)
+}
+
+// broadcastPresence must be called with the lock held
+func (r *Room) broadcastPresence(m Member, state *memberState) {
+	state.lastPresence = time.Now()
+	r.broadcast(r.presenceMessage(m, state), m)
+}
+
+func (r *Room) presenceMessage(m Member, state *memberState) models.DocumentMessage {
+	participant := m.Participant()
+	participant.Selection = state.selection
+
+	return models.DocumentMessage{
+		Type:        "presence",
+		DocumentID:  r.ID,
+		UserID:      participant.UserID,
+		SessionID:   participant.SessionID,
+		Participant: &participant,
+	}
+}
+
+// expirePresence hides the cursors of members not seen since the cutoff
+func (r *Room) expirePresence(cutoff time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for member, state := range r.members {
+		if state.selection == nil || !member.LastSeen().Before(cutoff) {
+			continue
+		}
+
+		state.selection = nil
+		if state.presenceTimer != nil {
+			state.presenceTimer.Stop()
+			state.presenceTimer = nil
+		}
+		r.broadcastPresence(member, state)
+	}
+}
+
+// transformSelections moves every tracked selection through an applied edit
+func (r *Room) transformSelections(ops []models.TextOp) {
+	for _, state := range r.members {
+		if state.selection == nil {
+			continue
+		}
+		state.selection = &models.Selection{
+			Anchor: TransformPosition(state.selection.Anchor, ops),
+			Head:   TransformPosition(state.selection.Head, ops),
+		}
+	}
+}
+
+// presence lists the members along with their selections
+func (r *Room) presence() []models.Participant {
+	presence := make([]models.Participant, 0, len(r.members))
+	for member, state := range r.members {
+		participant := member.Participant()
+		participant.Selection = state.selection
+		presence = append(presence, participant)
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		return presence[i].SessionID < presence[j].SessionID
+	})
+
+	return presence
+}
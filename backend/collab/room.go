@@ -0,0 +1,396 @@
+package collab
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"codecollab/models"
+	"codecollab/store"
+)
+
+// maxHistory is how many past edits a room keeps to transform late edits against
+const maxHistory = 1000
+
+// ErrStaleRevision is returned for edits based on a revision the room no
+// longer has history for; the client has to rejoin to resynchronize
+var ErrStaleRevision = errors.New("edit is based on a revision that is too old")
+
+// Member is a connection taking part in a room
+type Member interface {
+	// Participant identifies the member; its selection is tracked by the room
+	Participant() models.Participant
+	LastSeen() time.Time
+	Send(message interface{}) error
+	// Evicted tells the member it was removed from the document because
+	// its access was taken away
+	Evicted(documentID string, err error)
+}
+
+// Room holds the authoritative text of a document and the members editing it
+type Room struct {
+	ID        string
+	language  string
+	content   string
+	revision  int
+	createdAt time.Time
+	updatedAt time.Time
+
+	// history holds the edits that produced the revisions from historyStart
+	// onwards, as applied to the document
+	history      [][]models.TextOp
+	historyStart int
+
+	members map[Member]*memberState
+	mu      sync.Mutex
+
+	// access is who may use the document; accessVersion counts changes to it
+	access        access
+	accessVersion int
+
+	// threads are the document's comment threads, oldest first;
+	// threadsVersion counts changes to them, including moved ranges
+	threads        []*models.CommentThread
+	threadsVersion int
+
+	// pending holds the revisions not yet appended to the store's log
+	pending []models.Revision
+
+	// savedRevision is the last revision written to the store and
+	// snapshotRevision the last one with a full snapshot; saveMu orders
+	// writes so an older state never overwrites a newer one
+	savedRevision       int
+	savedThreadsVersion int
+	savedAccessVersion  int
+	snapshotRevision    int
+	closed              bool
+	saveMu              sync.Mutex
+}
+
+// roomState is what a room has to save
+type roomState struct {
+	doc       *store.Document
+	revisions []models.Revision
+
+	// threads is nil when they have not changed since the last save
+	threads        []models.CommentThread
+	threadsVersion int
+	accessVersion  int
+}
+
+func newRoom(doc *store.Document, snapshotRevision int, threads []models.CommentThread) *Room {
+	room := &Room{
+		ID:               doc.ID,
+		language:         doc.Language,
+		content:          doc.Content,
+		revision:         doc.Revision,
+		createdAt:        doc.CreatedAt,
+		updatedAt:        doc.UpdatedAt,
+		historyStart:     doc.Revision,
+		savedRevision:    doc.Revision,
+		snapshotRevision: snapshotRevision,
+		members:          make(map[Member]*memberState),
+		access:           documentAccess(doc),
+	}
+	for i := range threads {
+		room.threads = append(room.threads, &threads[i])
+	}
+	return room
+}
+
+// Snapshot returns the document language, current text and its revision
+func (r *Room) Snapshot() (language, content string, revision int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.language, r.content, r.revision
+}
+
+// unsaved returns the room's state for saving and whether it has changed
+// since it was last saved
+func (r *Room) unsaved() (*roomState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := &roomState{
+		doc: &store.Document{
+			ID:        r.ID,
+			Language:  r.language,
+			Content:   r.content,
+			OwnerID:   r.access.ownerID,
+			Revision:  r.revision,
+			CreatedAt: r.createdAt,
+			UpdatedAt: r.updatedAt,
+
+			Collaborators: maps.Clone(r.access.collaborators),
+			ShareLinks:    slices.Clone(r.access.links),
+		},
+		revisions:      append([]models.Revision(nil), r.pending...),
+		threadsVersion: r.threadsVersion,
+		accessVersion:  r.accessVersion,
+	}
+	if r.threadsVersion != r.savedThreadsVersion {
+		state.threads = r.commentThreads()
+	}
+
+	changed := r.revision != r.savedRevision || state.threads != nil || r.accessVersion != r.savedAccessVersion
+	return state, changed
+}
+
+// saved records that the state has been stored
+func (r *Room) saved(state *roomState, snapshot bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.savedRevision = state.doc.Revision
+	r.savedThreadsVersion = state.threadsVersion
+	r.savedAccessVersion = state.accessVersion
+	if snapshot {
+		r.snapshotRevision = state.doc.Revision
+	}
+
+	i := 0
+	for i < len(r.pending) && r.pending[i].Revision <= state.doc.Revision {
+		i++
+	}
+	r.pending = append([]models.Revision(nil), r.pending[i:]...)
+}
+
+// Participants lists the user IDs of the room members
+func (r *Room) Participants() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.participants()
+}
+
+// ApplyEdit applies an edit a member made to the given revision of the
+// document. The edit is transformed against every edit applied since that
+// revision, then acknowledged to the sender, answering the request with
+// requestID, and forwarded to everyone else
+// in its transformed form. Concurrent inserts at the same position keep the
+// later edit's text first, matching how clients transform pending edits.
+func (r *Room) ApplyEdit(from Member, requestID string, revision int, ops []models.TextOp) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if revision > r.revision || revision < 0 {
+		return 0, fmt.Errorf("%w: unknown revision %d", ErrInvalidOp, revision)
+	}
+	if revision < r.historyStart {
+		return 0, ErrStaleRevision
+	}
+
+	concurrent := r.history[revision-r.historyStart:]
+
+	length := utf8.RuneCountInString(r.content)
+	if len(concurrent) > 0 {
+		length = BaseLength(concurrent[0])
+	}
+
+	ops, err := Normalize(ops, length)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, applied := range concurrent {
+		if ops, _, err = Transform(ops, applied); err != nil {
+			return 0, err
+		}
+	}
+
+	participant := from.Participant()
+	if err := r.apply(ops, participant.UserID, participant.SessionID); err != nil {
+		return 0, err
+	}
+
+	from.Send(models.DocumentMessage{
+		Type:       "ack",
+		ID:         requestID,
+		DocumentID: r.ID,
+		Revision:   r.revision,
+	})
+	r.broadcast(models.DocumentMessage{
+		Type:       "edit",
+		DocumentID: r.ID,
+		UserID:     participant.UserID,
+		SessionID:  participant.SessionID,
+		Ops:        ops,
+		Revision:   r.revision,
+	}, from)
+
+	return r.revision, nil
+}
+
+// Restore replaces the document text with content as a single edit by the
+// user, sent to every member as an ordinary edit
+func (r *Room) Restore(content, userID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The diff leaves out the retain of the unchanged end, which edits kept in
+	// the history and sent to clients must cover
+	ops, err := Normalize(DiffOps(r.content, content), utf8.RuneCountInString(r.content))
+	if err != nil {
+		return 0, err
+	}
+	if err := r.apply(ops, userID, ""); err != nil {
+		return 0, err
+	}
+
+	r.broadcast(models.DocumentMessage{
+		Type:       "edit",
+		DocumentID: r.ID,
+		UserID:     userID,
+		Ops:        ops,
+		Revision:   r.revision,
+	}, nil)
+
+	return r.revision, nil
+}
+
+// apply must be called with the lock held. The ops must apply to the
+// current revision; they become the next one.
+func (r *Room) apply(ops []models.TextOp, userID, sessionID string) error {
+	content, err := Apply(r.content, ops)
+	if err != nil {
+		return err
+	}
+
+	r.content = content
+	r.revision++
+	r.updatedAt = time.Now()
+	r.transformSelections(ops)
+	r.transformThreads(ops)
+	r.history = append(r.history, ops)
+	if len(r.history) > maxHistory {
+		trim := len(r.history) - maxHistory
+		r.history = append([][]models.TextOp(nil), r.history[trim:]...)
+		r.historyStart += trim
+	}
+	r.pending = append(r.pending, models.Revision{
+		Revision:  r.revision,
+		UserID:    userID,
+		SessionID: sessionID,
+		Ops:       ops,
+		Timestamp: r.updatedAt,
+	})
+
+	return nil
+}
+
+// Broadcast sends a message to every member except the given one, which may be nil
+func (r *Room) Broadcast(message interface{}, except Member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.broadcast(message, except)
+}
+
+// broadcast must be called with the lock held so members see messages in
+// the same order the room applied them
+func (r *Room) broadcast(message interface{}, except Member) {
+	for member := range r.members {
+		if member == except {
+			continue
+		}
+		member.Send(message)
+	}
+}
+
+// join adds the member if its user may view the document, directly or
+// through the share link token, and sends it the "joined" message
+// describing the document, answering the request with requestID, before any
+// later edit can reach it
+func (r *Room) join(m Member, requestID, shareToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	participant := m.Participant()
+	role, err := r.access.authorize(r.ID, participant.UserID, shareToken, PermView)
+	if err != nil {
+		return err
+	}
+
+	r.broadcast(models.DocumentMessage{
+		Type:        "participant_joined",
+		DocumentID:  r.ID,
+		UserID:      participant.UserID,
+		SessionID:   participant.SessionID,
+		Participant: &participant,
+	}, nil)
+	r.members[m] = &memberState{shareToken: shareToken}
+
+	m.Send(models.DocumentMessage{
+		Type:         "joined",
+		ID:           requestID,
+		DocumentID:   r.ID,
+		Language:     r.language,
+		Content:      r.content,
+		Revision:     r.revision,
+		Participants: r.participants(),
+		Presence:     r.presence(),
+		Threads:      r.commentThreads(),
+		Role:         role,
+	})
+	return nil
+}
+
+func (r *Room) empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.members) == 0
+}
+
+// leave removes the member and reports whether the room is now empty
+func (r *Room) leave(m Member) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state, exists := r.members[m]; exists {
+		r.remove(m, state)
+	}
+	return len(r.members) == 0
+}
+
+// remove must be called with the lock held
+func (r *Room) remove(m Member, state *memberState) {
+	if state.presenceTimer != nil {
+		state.presenceTimer.Stop()
+	}
+	delete(r.members, m)
+
+	participant := m.Participant()
+	r.broadcast(models.DocumentMessage{
+		Type:       "participant_left",
+		DocumentID: r.ID,
+		UserID:     participant.UserID,
+		SessionID:  participant.SessionID,
+	}, nil)
+}
+
+// Has reports whether the member is in the room; members are removed
+// without leaving when they lose access
+func (r *Room) Has(m Member) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.members[m]
+	return exists
+}
+
+func (r *Room) participants() []string {
+	participants := make([]string, 0, len(r.members))
+	for member := range r.members {
+		participants = append(participants, member.Participant().UserID)
+	}
+	sort.Strings(participants)
+
+	return participants
+}
@@ -0,0 +1,89 @@
+package collab
+
+import (
+	"testing"
+
+	"codecollab/models"
+)
+
+func TestRestoreKeepsEarlierRevisionsEditable(t *testing.T) {
+	room := newTestRoom("hello\nworld\n")
+	editor, late := &simulatedMember{sessionID: "a"}, &simulatedMember{sessionID: "b"}
+	addTestMember(room, editor)
+	addTestMember(room, late)
+
+	if _, err := room.ApplyEdit(editor, "", 0, []models.TextOp{{Insert: "again\n"}, {Retain: 12}}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the start changes, so the diff ends with a retain
+	if _, err := room.Restore("hello\nworld\n", "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restore reaches members as an edit of the whole document
+	restore := editor.inbox[len(editor.inbox)-1].(models.DocumentMessage)
+	if length := BaseLength(restore.Ops); length != 18 {
+		t.Fatalf("restore edit spans %d characters, want 18", length)
+	}
+	if got := mustApply(t, "again\nhello\nworld\n", restore.Ops); got != "hello\nworld\n" {
+		t.Fatalf("restore edit gives %q, want %q", got, "hello\nworld\n")
+	}
+
+	// An edit made before the restore arrives after it
+	if _, err := room.ApplyEdit(late, "", 1, []models.TextOp{{Retain: 18}, {Insert: "late"}}); err != nil {
+		t.Fatalf("edit from before the restore: %v", err)
+	}
+	content, revision := textAndRevision(room)
+	if revision != 3 || content != "hello\nworld\nlate" {
+		t.Errorf("content = %q at revision %d, want %q at revision 3", content, revision, "hello\nworld\nlate")
+	}
+}
+
+func TestAnswersCarryRequestID(t *testing.T) {
+	room := newTestRoom("hello")
+	author, other := &simulatedMember{sessionID: "a"}, &simulatedMember{sessionID: "b"}
+	addTestMember(room, author)
+	addTestMember(room, other)
+
+	lastMessage := func(m *simulatedMember) models.DocumentMessage {
+		return m.inbox[len(m.inbox)-1].(models.DocumentMessage)
+	}
+
+	thread, err := room.AddThread(author, "1", models.Range{From: 0, To: 5}, "Greeting?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := room.Reply(author, "2", thread.ID, "Yes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := room.Resolve(author, "3", thread.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"1", "2", "3"} {
+		// Both receive every thread message, which only the author's copy answers
+		sent, forwarded := author.inbox[i].(models.DocumentMessage), other.inbox[i].(models.DocumentMessage)
+		if sent.Type != "thread" || sent.ID != id {
+			t.Errorf("author received %s with ID %q, want thread with ID %q", sent.Type, sent.ID, id)
+		}
+		if forwarded.Type != "thread" || forwarded.ID != "" {
+			t.Errorf("other member received %s with ID %q, want thread without one", forwarded.Type, forwarded.ID)
+		}
+	}
+
+	// Presence is answered at once, with the selection clamped
+	room.UpdatePresence(author, "4", &models.Selection{Anchor: 2, Head: 20})
+	answer := lastMessage(author)
+	if answer.Type != "presence" || answer.ID != "4" || answer.Participant.Selection.Head != 5 {
+		t.Errorf("presence answered with %s, ID %q, selection %+v", answer.Type, answer.ID, answer.Participant.Selection)
+	}
+	if forwarded := lastMessage(other); forwarded.Type != "presence" || forwarded.ID != "" {
+		t.Errorf("other member received %s with ID %q, want presence without one", forwarded.Type, forwarded.ID)
+	}
+
+	// Presence without an ID goes only to the others
+	received := len(author.inbox)
+	room.UpdatePresence(author, "", nil)
+	if len(author.inbox) != received {
+		t.Errorf("presence without an ID was answered with %+v", lastMessage(author))
+	}
+}
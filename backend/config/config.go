@@ -3,16 +3,105 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 
+	// Authentication providers tried in order: supabase, oidc, apikey, mock
+	AuthProviders []string
+
 	SupabaseURL     string
 	SupabaseAnonKey string
 
+	// Local verification of Supabase JWTs: HS256 with the project secret, or
+	// RS256/ES256 with keys from the JWKS URL, cached for the refresh interval
+	SupabaseJWTSecret   string
+	SupabaseJWKSURL     string
+	SupabaseJWTAudience string
+	SupabaseJWTIssuer   string
+	JWKSRefreshInterval time.Duration
+
+	// Ask Supabase to verify tokens when the signing key is not available locally
+	SupabaseRemoteVerify bool
+	// Claims holding the user's roles and tenant, as dotted paths
+	SupabaseRolesClaim  string
+	SupabaseTenantClaim string
+
+	// Generic OpenID Connect provider, found through its discovery document
+	OIDCIssuerURL   string
+	OIDCAudience    string
+	OIDCRolesClaim  string
+	OIDCTenantClaim string
+
+	// JSON file of static API keys and the principals they authenticate
+	APIKeysFile string
+
+	// How long a WebSocket ticket can be used for, and whether WebSockets may
+	// still pass an access token in the query string
+	WSTicketTTL  time.Duration
+	WSQueryToken bool
+
+	// Warn WebSocket clients this long before their credentials expire
+	TokenExpiryWarning time.Duration
+	// How long revocations last, at least as long as credentials are valid
+	RevocationTTL time.Duration
+	// Role a principal needs for the admin API
+	AdminRole string
+
+	// Rate limits by "plan.action", and the plan of users without a plan role
+	RateLimits           map[string]string
+	RateLimitDefaultPlan string
+
+	// Where rate limit buckets are kept, "memory" or "redis" to share them
+	// between instances, whether buckets are kept in memory while Redis
+	// fails, and otherwise whether requests are allowed when the store fails
+	RateLimitStore          string
+	RateLimitRedisPrefix    string
+	RateLimitTimeout        time.Duration
+	RateLimitMemoryFallback bool
+	RateLimitFailOpen       bool
+	RedisURL                string
+
+	// Reverse proxies whose X-Forwarded-For header is trusted, as CIDR ranges
+	TrustedProxies []string
+
+	// WebSocket connection attempts allowed per IP, as "requests/period:burst",
+	// and the most sockets open at once per user and per IP, 0 for no limit
+	WSConnectRateLimit string
+	WSMaxConnsPerUser  int
+	WSMaxConnsPerIP    int
+	// An IP failing authentication this many times within the window is
+	// refused for the ban duration
+	WSAuthFailureLimit  int
+	WSAuthFailureWindow time.Duration
+	WSAuthBanDuration   time.Duration
+
+	// Analyses a WebSocket connection may have in progress at once
+	WSMaxConcurrentRequests int
+
+	// Messages queued for a WebSocket connection and how long it may stay
+	// full, how long a write may take, how often connections are pinged and
+	// how long they have to answer, and how long a connection may go without
+	// a request before it is closed
+	WSSendQueueSize     int
+	WSSlowConsumerGrace time.Duration
+	WSWriteTimeout      time.Duration
+	WSPingInterval      time.Duration
+	WSPongTimeout       time.Duration
+	WSIdleTimeout       time.Duration
+
+	// Linter quotas by "plan.period" and "tenant.period", as
+	// "analyses=N,bytes=N,time=D" with missing entries unlimited
+	Quotas map[string]string
+	// Where linter use is counted, "bolt" or "memory"
+	UsageStore     string
+	UsageStorePath string
 
 	AWSRegion          string
 	AWSAccessKeyID     string
@@ -25,6 +114,44 @@ type Config struct {
 	LambdaARNGo         string
 	LambdaARNCpp        string
 
+	// Linter backend used for each language, e.g. "lambda" or "mock"
+	LinterBackend  string
+	LinterBackends map[string]string
+
+	// Local subprocess linter, command per language with "{file}" as the source path
+	LocalLinterCommands   map[string]string
+	LocalLinterTimeout    time.Duration
+	LocalLinterMemoryMB   int
+	LocalLinterCPUSeconds int
+
+	// HTTP linter services speaking the Lambda request/response contract
+	HTTPLinterURLs               map[string]string
+	HTTPLinterTimeout            time.Duration
+	HTTPLinterAuthHeader         string
+	HTTPLinterAuthToken          string
+	HTTPLinterCAFile             string
+	HTTPLinterClientCert         string
+	HTTPLinterClientKey          string
+	HTTPLinterInsecureSkipVerify bool
+
+	// go/analysis passes run by the built-in Go analyzer
+	GoAnalyzerPasses []string
+
+	// Cursors of users idle for longer than this are hidden from collaborators
+	PresenceIdleTimeout time.Duration
+
+	// Document persistence, "bolt" or "memory"
+	DocumentStore         string
+	DocumentStorePath     string
+	DocumentFlushInterval time.Duration
+
+	// Revisions between full snapshots in a document's history
+	DocumentSnapshotInterval int
+
+	// Default and longest lifetime of document share links
+	ShareLinkTTL    time.Duration
+	ShareLinkMaxTTL time.Duration
+
 	
 	Port string
 	Env  string
@@ -35,31 +162,170 @@ type Config struct {
 	UseMockAuth   bool
 }
 
+// SupportedLanguages lists the canonical languages a linter can be configured for
+var SupportedLanguages = []string{"typescript", "python", "dart", "go", "cpp"}
+
+// RateLimitPlans lists the plans users are rate limited by, most generous
+// first. A user is on the first plan they have a role for.
+var RateLimitPlans = []string{"internal", "pro", "free"}
+
+// RateLimitActions lists the actions with a rate limit budget of their own;
+// other actions share the DefaultRateLimitAction budget
+var RateLimitActions = []string{"analyze", "edit", DefaultRateLimitAction}
+
+const DefaultRateLimitAction = "default"
+
+// defaultRateLimits are the limits of each plan and action, written as
+// "requests/period:burst" or "unlimited"
+var defaultRateLimits = map[string]string{
+	"free.analyze":     "60/1m:10",
+	"free.edit":        "1200/1m:100",
+	"free.default":     "600/1m:60",
+	"pro.analyze":      "300/1m:30",
+	"pro.edit":         "3000/1m:300",
+	"pro.default":      "1200/1m:120",
+	"internal.analyze": "unlimited",
+	"internal.edit":    "unlimited",
+	"internal.default": "unlimited",
+}
+
+// QuotaPeriods lists the periods linter use is counted over, starting each
+// UTC day and month
+var QuotaPeriods = []string{"daily", "monthly"}
+
+// QuotaTenant stands in for the plan in the quotas shared by each tenant
+const QuotaTenant = "tenant"
+
+// defaultQuotas are the quotas of each plan and period, and of every tenant
+var defaultQuotas = map[string]string{
+	"free.daily":       "analyses=500,bytes=50MB,time=10m",
+	"free.monthly":     "analyses=5000,bytes=500MB,time=2h",
+	"pro.daily":        "analyses=5000,bytes=500MB,time=2h",
+	"pro.monthly":      "analyses=100000,bytes=10GB,time=40h",
+	"internal.daily":   "unlimited",
+	"internal.monthly": "unlimited",
+	"tenant.daily":     "unlimited",
+	"tenant.monthly":   "unlimited",
+}
+
 func Load() *Config {
 	
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
+	useMockLambda := getBoolEnv("USE_MOCK_LAMBDA", false)
+	defaultBackend := "lambda"
+	if useMockLambda {
+		defaultBackend = "mock"
+	}
+
+	supabaseURL := strings.TrimSuffix(getEnv("SUPABASE_URL", ""), "/")
+	var supabaseJWKSURL, supabaseIssuer string
+	if supabaseURL != "" {
+		supabaseJWKSURL = supabaseURL + "/auth/v1/.well-known/jwks.json"
+		supabaseIssuer = supabaseURL + "/auth/v1"
+	}
+
+	useMockAuth := getBoolEnv("USE_MOCK_AUTH", false)
+	defaultAuthProvider := "supabase"
+	if useMockAuth {
+		defaultAuthProvider = "mock"
+	}
+
 	return &Config{
-		SupabaseURL:         getEnv("SUPABASE_URL", ""),
-		SupabaseAnonKey:     getEnv("SUPABASE_ANON_KEY", ""),
-		AWSRegion:           getEnv("AWS_REGION", "us-east-1"),
-		AWSAccessKeyID:      getEnv("AWS_ACCESS_KEY_ID", ""),
-		AWSSecretAccessKey:  getEnv("AWS_SECRET_ACCESS_KEY", ""),
-		LambdaARNTypeScript: getEnv("LAMBDA_ARN_TYPESCRIPT", ""),
-		LambdaARNPython:     getEnv("LAMBDA_ARN_PYTHON", ""),
-		LambdaARNDart:       getEnv("LAMBDA_ARN_DART", ""),
-		LambdaARNGo:         getEnv("LAMBDA_ARN_GO", ""),
-		LambdaARNCpp:        getEnv("LAMBDA_ARN_CPP", ""),
-		Port:                getEnv("PORT", "8080"),
-		Env:                 getEnv("ENV", "development"),
-		LokiURL:             getEnv("LOKI_URL", "http://loki:3100"),
-		UseMockLambda:       getBoolEnv("USE_MOCK_LAMBDA", false),
-		UseMockAuth:         getBoolEnv("USE_MOCK_AUTH", false),
+		AuthProviders:                getListEnv("AUTH_PROVIDERS", []string{defaultAuthProvider}),
+		SupabaseURL:                  supabaseURL,
+		SupabaseAnonKey:              getEnv("SUPABASE_ANON_KEY", ""),
+		SupabaseJWTSecret:            getEnv("SUPABASE_JWT_SECRET", ""),
+		SupabaseJWKSURL:              getEnv("SUPABASE_JWKS_URL", supabaseJWKSURL),
+		SupabaseJWTAudience:          getEnv("SUPABASE_JWT_AUDIENCE", "authenticated"),
+		SupabaseJWTIssuer:            getEnv("SUPABASE_JWT_ISSUER", supabaseIssuer),
+		JWKSRefreshInterval:          getDurationEnv("JWKS_REFRESH_INTERVAL", 10*time.Minute),
+		SupabaseRemoteVerify:         getBoolEnv("SUPABASE_REMOTE_VERIFY", true),
+		SupabaseRolesClaim:           getEnv("SUPABASE_ROLES_CLAIM", "app_metadata.roles"),
+		SupabaseTenantClaim:          getEnv("SUPABASE_TENANT_CLAIM", "app_metadata.tenant_id"),
+		OIDCIssuerURL:                getEnv("OIDC_ISSUER_URL", ""),
+		OIDCAudience:                 getEnv("OIDC_AUDIENCE", ""),
+		OIDCRolesClaim:               getEnv("OIDC_ROLES_CLAIM", "roles"),
+		OIDCTenantClaim:              getEnv("OIDC_TENANT_CLAIM", "tenant"),
+		APIKeysFile:                  getEnv("AUTH_API_KEYS_FILE", ""),
+		WSTicketTTL:                  getDurationEnv("WS_TICKET_TTL", 30*time.Second),
+		WSQueryToken:                 getBoolEnv("WS_QUERY_TOKEN", true),
+		TokenExpiryWarning:           getDurationEnv("TOKEN_EXPIRY_WARNING", time.Minute),
+		RevocationTTL:                getDurationEnv("REVOCATION_TTL", 24*time.Hour),
+		AdminRole:                    getEnv("ADMIN_ROLE", "admin"),
+		RateLimits:                   getRateLimitEnv(),
+		RateLimitDefaultPlan:         getEnv("RATE_LIMIT_DEFAULT_PLAN", "free"),
+		RateLimitStore:               getEnv("RATE_LIMIT_STORE", "memory"),
+		RateLimitRedisPrefix:         getEnv("RATE_LIMIT_REDIS_PREFIX", "ratelimit:"),
+		RateLimitTimeout:             getDurationEnv("RATE_LIMIT_TIMEOUT", 250*time.Millisecond),
+		RateLimitMemoryFallback:      getBoolEnv("RATE_LIMIT_MEMORY_FALLBACK", true),
+		RateLimitFailOpen:            getBoolEnv("RATE_LIMIT_FAIL_OPEN", true),
+		RedisURL:                     getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		Quotas:                       getQuotaEnv(),
+		UsageStore:                   getEnv("USAGE_STORE", "bolt"),
+		UsageStorePath:               getEnv("USAGE_STORE_PATH", "data/usage.db"),
+		TrustedProxies:               getListEnv("TRUSTED_PROXIES", []string{}),
+		WSConnectRateLimit:           getEnv("WS_CONNECT_RATE_LIMIT", "30/1m:10"),
+		WSMaxConnsPerUser:            getIntEnv("WS_MAX_CONNECTIONS_PER_USER", 10),
+		WSMaxConnsPerIP:              getIntEnv("WS_MAX_CONNECTIONS_PER_IP", 50),
+		WSAuthFailureLimit:           getIntEnv("WS_AUTH_FAILURE_LIMIT", 10),
+		WSAuthFailureWindow:          getDurationEnv("WS_AUTH_FAILURE_WINDOW", 5*time.Minute),
+		WSAuthBanDuration:            getDurationEnv("WS_AUTH_BAN_DURATION", 15*time.Minute),
+		WSMaxConcurrentRequests:      getIntEnv("WS_MAX_CONCURRENT_REQUESTS", 4),
+		WSSendQueueSize:              getIntEnv("WS_SEND_QUEUE_SIZE", 256),
+		WSSlowConsumerGrace:          getDurationEnv("WS_SLOW_CONSUMER_GRACE", 5*time.Second),
+		WSWriteTimeout:               getDurationEnv("WS_WRITE_TIMEOUT", 10*time.Second),
+		WSPingInterval:               getDurationEnv("WS_PING_INTERVAL", 30*time.Second),
+		WSPongTimeout:                getDurationEnv("WS_PONG_TIMEOUT", 60*time.Second),
+		WSIdleTimeout:                getDurationEnv("WS_IDLE_TIMEOUT", time.Hour),
+		AWSRegion:                    getEnv("AWS_REGION", "us-east-1"),
+		AWSAccessKeyID:               getEnv("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey:           getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		LambdaARNTypeScript:          getEnv("LAMBDA_ARN_TYPESCRIPT", ""),
+		LambdaARNPython:              getEnv("LAMBDA_ARN_PYTHON", ""),
+		LambdaARNDart:                getEnv("LAMBDA_ARN_DART", ""),
+		LambdaARNGo:                  getEnv("LAMBDA_ARN_GO", ""),
+		LambdaARNCpp:                 getEnv("LAMBDA_ARN_CPP", ""),
+		LinterBackend:                getEnv("LINTER_BACKEND", defaultBackend),
+		LinterBackends:               getLanguageEnv("LINTER_BACKEND_"),
+		LocalLinterCommands:          getLanguageEnv("LINTER_LOCAL_CMD_"),
+		LocalLinterTimeout:           getDurationEnv("LINTER_LOCAL_TIMEOUT", 10*time.Second),
+		LocalLinterMemoryMB:          getIntEnv("LINTER_LOCAL_MEMORY_MB", 1024),
+		LocalLinterCPUSeconds:        getIntEnv("LINTER_LOCAL_CPU_SECONDS", 10),
+		HTTPLinterURLs:               getLanguageEnv("LINTER_HTTP_URL_"),
+		HTTPLinterTimeout:            getDurationEnv("LINTER_HTTP_TIMEOUT", 15*time.Second),
+		HTTPLinterAuthHeader:         getEnv("LINTER_HTTP_AUTH_HEADER", "Authorization"),
+		HTTPLinterAuthToken:          getEnv("LINTER_HTTP_AUTH_TOKEN", ""),
+		HTTPLinterCAFile:             getEnv("LINTER_HTTP_CA_FILE", ""),
+		HTTPLinterClientCert:         getEnv("LINTER_HTTP_CLIENT_CERT", ""),
+		HTTPLinterClientKey:          getEnv("LINTER_HTTP_CLIENT_KEY", ""),
+		HTTPLinterInsecureSkipVerify: getBoolEnv("LINTER_HTTP_INSECURE_SKIP_VERIFY", false),
+		GoAnalyzerPasses:             getListEnv("GO_ANALYZER_PASSES", []string{"printf", "unusedresult"}),
+		PresenceIdleTimeout:          getDurationEnv("PRESENCE_IDLE_TIMEOUT", 2*time.Minute),
+		DocumentStore:                getEnv("DOCUMENT_STORE", "bolt"),
+		DocumentStorePath:            getEnv("DOCUMENT_STORE_PATH", "data/codecollab.db"),
+		DocumentFlushInterval:        getDurationEnv("DOCUMENT_FLUSH_INTERVAL", 10*time.Second),
+		DocumentSnapshotInterval:     getIntEnv("DOCUMENT_SNAPSHOT_INTERVAL", 100),
+		ShareLinkTTL:                 getDurationEnv("SHARE_LINK_TTL", 7*24*time.Hour),
+		ShareLinkMaxTTL:              getDurationEnv("SHARE_LINK_MAX_TTL", 30*24*time.Hour),
+		Port:                         getEnv("PORT", "8080"),
+		Env:                          getEnv("ENV", "development"),
+		LokiURL:                      getEnv("LOKI_URL", "http://loki:3100"),
+		UseMockLambda:                useMockLambda,
+		UseMockAuth:                  useMockAuth,
 	}
 }
 
+// LinterBackendFor returns the linter backend configured for a canonical language
+func (c *Config) LinterBackendFor(language string) string {
+	if backend, exists := c.LinterBackends[language]; exists {
+		return backend
+	}
+	return c.LinterBackend
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -75,3 +341,83 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv splits a comma-separated variable; "none" gives an empty list
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	if value == "none" {
+		return []string{}
+	}
+
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// getLanguageEnv collects the prefix+LANGUAGE variables that are set, keyed by canonical language
+func getLanguageEnv(prefix string) map[string]string {
+	values := make(map[string]string)
+	for _, language := range SupportedLanguages {
+		if value := getEnv(prefix+strings.ToUpper(language), ""); value != "" {
+			values[language] = value
+		}
+	}
+	return values
+}
+
+// getRateLimitEnv reads RATE_LIMIT_<PLAN>_<ACTION> for every plan and action,
+// falling back to defaultRateLimits
+func getRateLimitEnv() map[string]string {
+	values := make(map[string]string)
+	for _, plan := range RateLimitPlans {
+		for _, action := range RateLimitActions {
+			key := plan + "." + action
+			name := "RATE_LIMIT_" + strings.ToUpper(plan) + "_" + strings.ToUpper(action)
+			if value := getEnv(name, defaultRateLimits[key]); value != "" {
+				values[key] = value
+			}
+		}
+	}
+	return values
+}
+
+// getQuotaEnv reads QUOTA_<PLAN>_<PERIOD> for every plan and period, and
+// QUOTA_TENANT_<PERIOD>, falling back to defaultQuotas
+func getQuotaEnv() map[string]string {
+	values := make(map[string]string)
+	for _, plan := range slices.Concat(RateLimitPlans, []string{QuotaTenant}) {
+		for _, period := range QuotaPeriods {
+			key := plan + "." + period
+			name := "QUOTA_" + strings.ToUpper(plan) + "_" + strings.ToUpper(period)
+			if value := getEnv(name, defaultQuotas[key]); value != "" {
+				values[key] = value
+			}
+		}
+	}
+	return values
+}
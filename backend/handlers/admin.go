@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"codecollab/auth"
+)
+
+// HandleRevocations serves GET and POST /api/v1/admin/revocations, listing
+// the revocations in effect or revoking the credentials of a user or login
+// session, or banning a user, which also closes their WebSocket connections
+func HandleRevocations(authenticator auth.Authenticator, revocations *auth.Revocations, adminRole string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateAdmin(w, r, authenticator, adminRole)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"revocations": revocations.List(),
+			})
+			return
+		}
+
+		var body struct {
+			UserID    string `json:"userId"`
+			SessionID string `json:"sessionId"`
+			Ban       bool   `json:"ban"`
+			Reason    string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Body must be a JSON object")
+			return
+		}
+
+		revocation, err := revocations.Revoke(body.UserID, body.SessionID, body.Ban, body.Reason, principal.UserID)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, revocation)
+	}
+}
+
+// HandleRevocation serves DELETE /api/v1/admin/revocations/{id}, lifting a
+// revocation before it expires
+func HandleRevocation(authenticator auth.Authenticator, revocations *auth.Revocations, adminRole string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateAdmin(w, r, authenticator, adminRole)
+		if !ok {
+			return
+		}
+
+		id := r.PathValue("id")
+		err := revocations.Lift(id)
+		if errors.Is(err, auth.ErrRevocationNotFound) {
+			writeAPIError(w, http.StatusNotFound, "Revocation not found: "+id)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to lift revocation %s: %v", id, err)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to lift revocation")
+			return
+		}
+
+		logger.Info("User %s lifted revocation %s", principal.UserID, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authenticateAdmin authenticates the request and checks that the principal
+// has the admin role, writing an error response if not
+func authenticateAdmin(w http.ResponseWriter, r *http.Request, authenticator auth.Authenticator, adminRole string) (*auth.Principal, bool) {
+	principal, ok := authenticateRequest(w, r, authenticator)
+	if !ok {
+		return nil, false
+	}
+	if adminRole == "" || !principal.HasRole(adminRole) {
+		logger.Warn("Denied admin request %s %s by user %s", r.Method, r.URL.Path, principal.UserID)
+		writeAPIError(w, http.StatusForbidden, "Admin role required")
+		return nil, false
+	}
+	return principal, true
+}
@@ -1,71 +1,175 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
+	"context"
 	"net/http"
+	"strings"
 	"time"
 
+	"codecollab/auth"
 	"codecollab/config"
+	"codecollab/models"
 	"codecollab/utils"
+
+	"github.com/gorilla/websocket"
 )
 
 var logger = utils.NewLogger("auth")
 
-type SupabaseUser struct {
-	ID    string `json:"id"`
-	Email string `json:"email"`
-}
+const (
+	// wsProtocol and wsProtocolV1 are the WebSocket subprotocols the server
+	// selects, for messages in the version 0 shape or in version 1
+	// envelopes, preferring version 1 when both are offered. Browsers only
+	// accept a selected protocol they offered, so clients passing a
+	// credential in Sec-WebSocket-Protocol must offer one too.
+	wsProtocol   = "codecollab"
+	wsProtocolV1 = "codecollab.v1"
+
+	// Sec-WebSocket-Protocol entries carrying a credential
+	ticketProtocolPrefix = "ticket."
+	bearerProtocolPrefix = "bearer."
+)
 
-func VerifyToken(token string, cfg *config.Config) (string, error) {
+// HandleWebSocketTicket serves POST /api/v1/ws-ticket, exchanging the bearer
+// token of the request for a single-use ticket to open a WebSocket with
+func HandleWebSocketTicket(authenticator auth.Authenticator, tickets *auth.TicketStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok {
+			return
+		}
+
+		ticket, expiresAt := tickets.Issue(principal)
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ticket":    ticket,
+			"expiresAt": expiresAt,
+			"expiresIn": int(time.Until(expiresAt).Round(time.Second) / time.Second),
+		})
+	}
+}
 
-	if cfg.UseMockAuth {
-		logger.Info("Using mock auth - accepting token: %s", token[:min(10, len(token))])
+// authenticateWebSocket returns the principal of a WebSocket upgrade request.
+// The credential is a ticket or an access token, passed in
+// Sec-WebSocket-Protocol, a ticket query parameter, an Authorization header
+// or, if allowed, a token query parameter.
+func authenticateWebSocket(r *http.Request, cfg *config.Config, authenticator auth.Authenticator, tickets *auth.TicketStore, revocations *auth.Revocations) (*auth.Principal, error) {
+	var ticket, token string
+	for _, protocol := range websocket.Subprotocols(r) {
+		if value, found := strings.CutPrefix(protocol, ticketProtocolPrefix); found {
+			ticket = value
+		} else if value, found := strings.CutPrefix(protocol, bearerProtocolPrefix); found {
+			token = value
+		}
+	}
 
-		return "mock-user-" + token[:min(8, len(token))], nil
+	if ticket == "" {
+		ticket = r.URL.Query().Get("ticket")
+	}
+	if ticket != "" {
+		principal, err := tickets.Redeem(ticket)
+		if err != nil {
+			return nil, err
+		}
+		// The credentials may have been revoked since the ticket was issued
+		return principal, revocations.Check(principal)
 	}
 
-	if cfg.SupabaseURL == "" || cfg.SupabaseAnonKey == "" {
-		return "", fmt.Errorf("Supabase configuration missing")
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
 	}
+	if token == "" && cfg.WSQueryToken {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return nil, auth.ErrMissingToken
+	}
+	return authenticator.Authenticate(r.Context(), token)
+}
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+// authenticateRequest verifies the bearer token of a REST request and
+// returns its principal, writing a 401 response if it is missing or invalid
+func authenticateRequest(w http.ResponseWriter, r *http.Request, authenticator auth.Authenticator) (*auth.Principal, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		writeAPIError(w, http.StatusUnauthorized, "Missing auth token")
+		return nil, false
 	}
 
-	url := fmt.Sprintf("%s/auth/v1/user", cfg.SupabaseURL)
-	req, err := http.NewRequest("GET", url, nil)
+	principal, err := authenticator.Authenticate(r.Context(), token)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		logger.Error("Failed to verify token: %v", err)
+		writeAPIError(w, http.StatusUnauthorized, "Invalid auth token")
+		return nil, false
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("apikey", cfg.SupabaseAnonKey)
+	return principal, true
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to verify token: %w", err)
+func min(a, b int) int {
+	if a < b {
+		return a
 	}
-	defer resp.Body.Close()
+	return b
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("invalid token: %s", string(body))
+// handleReauth replaces the connection's credentials with a fresh access
+// token for the same user before the current one expires
+func handleReauth(c *client, request models.AnalyzeRequest, cfg *config.Config, authenticator auth.Authenticator) {
+	if request.Token == "" {
+		sendErrorCode(c, request.ID, errorUnauthorized, "Missing token")
+		return
 	}
 
-	var user SupabaseUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return "", fmt.Errorf("failed to decode user: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	principal, err := authenticator.Authenticate(ctx, request.Token)
+	if err != nil {
+		logger.Warn("Failed to reauthenticate user %s: %v", c.principal.UserID, err)
+		sendErrorCode(c, request.ID, errorUnauthorized, "Invalid auth token")
+		return
+	}
+	if principal.UserID != c.principal.UserID {
+		logger.Warn("User %s tried to reauthenticate as user %s", c.principal.UserID, principal.UserID)
+		sendErrorCode(c, request.ID, errorUnauthorized, "Token is for a different user")
+		return
 	}
 
-	logger.Info("Token verified for user: %s", user.ID)
-	return user.ID, nil
+	connectionsMu.Lock()
+	c.principal = principal
+	c.info.ExpiresAt = principal.ExpiresAt
+	connectionsMu.Unlock()
+
+	c.watchExpiry(cfg.TokenExpiryWarning)
+
+	logger.Info("User %s reauthenticated, credentials expire %s", principal.UserID, principal.ExpiresAt.Format(time.RFC3339))
+	c.Send(models.AuthMessage{
+		Type:      "reauthenticated",
+		ID:        request.ID,
+		ExpiresAt: principal.ExpiresAt,
+	})
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// disconnectRevoked closes the connections whose credentials a revocation
+// covers
+func disconnectRevoked(revocation auth.Revocation) {
+	connectionsMu.RLock()
+	var revoked []*client
+	for _, c := range connections {
+		if revocation.Matches(c.principal) {
+			logger.Warn("Closing connection %s of user %s: credentials revoked", c.info.SessionID, c.principal.UserID)
+			revoked = append(revoked, c)
+		}
+	}
+	connectionsMu.RUnlock()
+
+	for _, c := range revoked {
+		c.closeWith(closeRevoked, "credentials revoked")
 	}
-	return b
 }
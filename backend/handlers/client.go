@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/collab"
+	"codecollab/config"
+	"codecollab/metrics"
+	"codecollab/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// presenceColors are assigned to users who have not picked a colour
+var presenceColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4",
+	"#42d4f4", "#f032e6", "#469990", "#9a6324", "#800000",
+}
+
+var (
+	// errAnalysisCanceled and errAnalysisSuperseded are why an analysis was
+	// abandoned before its result was sent
+	errAnalysisCanceled   = errors.New("analysis canceled")
+	errAnalysisSuperseded = errors.New("analysis superseded")
+
+	// errConnectionClosed is returned for messages sent after the
+	// connection closed
+	errConnectionClosed = errors.New("connection closed")
+)
+
+// client is a single WebSocket connection. Room broadcasts send to it from
+// other connections' goroutines, so messages are queued for its write pump,
+// the only goroutine writing to the connection, and never wait for it.
+type client struct {
+	conn      *websocket.Conn
+	principal *auth.Principal
+	// ip is the address the connection is counted under
+	ip string
+
+	// info is shared with the connections map and guarded by connectionsMu
+	info *models.Connection
+	// sessionID is info.SessionID, which never changes, for use without the lock
+	sessionID string
+
+	// rooms joined by this connection, only used from its read loop
+	hub   *collab.Hub
+	rooms map[string]*collab.Room
+
+	// version of the message format negotiated for the connection
+	version int
+
+	// send queues encoded messages for the write pump
+	send         *sendQueue
+	writeTimeout time.Duration
+	pingInterval time.Duration
+
+	// inFlight holds a token for each request being processed alongside
+	// the read loop
+	inFlight chan struct{}
+
+	// ctx is canceled when the connection closes, abandoning its requests
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// analyses in progress, to cancel them or supersede them with newer ones
+	analysesMu sync.Mutex
+	analyses   map[*analysis]struct{}
+
+	// timers warning of and acting on the expiry of the credentials
+	expiryMu     sync.Mutex
+	expiryTimers []*time.Timer
+}
+
+// analysis is an analysis in progress on a connection
+type analysis struct {
+	id         string
+	documentID string
+	cancel     context.CancelCauseFunc
+}
+
+func newClient(conn *websocket.Conn, principal *auth.Principal, ip string, hub *collab.Hub, cfg *config.Config) *client {
+	ctx, cancel := context.WithCancel(context.Background())
+	sessionID := newSessionID()
+	return &client{
+		conn:      conn,
+		principal: principal,
+		ip:        ip,
+		info: &models.Connection{
+			SessionID:   sessionID,
+			UserID:      principal.UserID,
+			DisplayName: principal.UserID,
+			Color:       defaultColor(principal.UserID),
+			LastSeen:    time.Now(),
+			ExpiresAt:   principal.ExpiresAt,
+		},
+		sessionID:    sessionID,
+		hub:          hub,
+		rooms:        make(map[string]*collab.Room),
+		version:      protocolVersion(conn.Subprotocol()),
+		send:         newSendQueue(cfg.WSSendQueueSize, cfg.WSSlowConsumerGrace, sessionID),
+		writeTimeout: cfg.WSWriteTimeout,
+		pingInterval: cfg.WSPingInterval,
+		inFlight:     make(chan struct{}, max(cfg.WSMaxConcurrentRequests, 1)),
+		ctx:          ctx,
+		cancel:       cancel,
+		analyses:     make(map[*analysis]struct{}),
+	}
+}
+
+// startRequest reserves a place for a request processed concurrently,
+// reporting false if they are all taken
+func (c *client) startRequest() bool {
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// finishRequest frees the place of a request started with startRequest
+func (c *client) finishRequest() {
+	<-c.inFlight
+}
+
+func (c *client) Participant() models.Participant {
+	connectionsMu.RLock()
+	defer connectionsMu.RUnlock()
+
+	return models.Participant{
+		SessionID:   c.info.SessionID,
+		UserID:      c.info.UserID,
+		DisplayName: c.info.DisplayName,
+		Color:       c.info.Color,
+	}
+}
+
+func (c *client) LastSeen() time.Time {
+	connectionsMu.RLock()
+	defer connectionsMu.RUnlock()
+
+	return c.info.LastSeen
+}
+
+// Evicted tells the client it was removed from a document it joined
+func (c *client) Evicted(documentID string, err error) {
+	wsLogger.Info("Removed session %s from document %s: %v", c.sessionID, documentID, err)
+	clearSelection(c, documentID)
+	c.Send(models.AnalyzeResponse{
+		Type:         "error",
+		DocumentID:   documentID,
+		ErrorMessage: "Removed from document: " + err.Error(),
+		ErrorCode:    errorForbidden,
+	})
+}
+
+// Send queues a JSON message for the connection in its message format,
+// without waiting. A client too slow to keep room in its queue is
+// disconnected, since it would miss messages it cannot do without; it
+// resynchronizes when it reconnects.
+func (c *client) Send(message interface{}) error {
+	data, err := encodeMessage(message, c.version)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	err = c.send.push(newOutbound(message, data))
+	if errors.Is(err, errSlowConsumer) {
+		c.send.close()
+		metrics.WebSocketSlowConsumers.Inc()
+		wsLogger.Warn("Closing connection %s, still %d messages behind after %v", c.sessionID, c.send.size, c.send.grace)
+		// closing writes to the connection, which must not hold up the sender
+		go c.closeWith(closeSlowConsumer, "slow consumer")
+	}
+	return err
+}
+
+// writePump writes queued messages to the connection and pings it, giving
+// up on a write that takes longer than the write timeout. It runs until the
+// connection closes, or closes it when a write fails.
+func (c *client) writePump() {
+	defer c.conn.Close()
+
+	var ping <-chan time.Time
+	if c.pingInterval > 0 {
+		ticker := time.NewTicker(c.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.send.ready:
+			data, ok := c.send.pop()
+			if !ok {
+				continue
+			}
+			c.conn.SetWriteDeadline(deadline(c.writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				wsLogger.Warn("Failed to write to connection %s: %v", c.sessionID, err)
+				return
+			}
+		case <-ping:
+			c.conn.SetWriteDeadline(deadline(c.writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// startAnalysis registers an analysis of the document, or of code sent
+// without one, returning the context it runs in. With supersede, analyses
+// of the same document already in progress are abandoned.
+func (c *client) startAnalysis(id, documentID string, supersede bool) (context.Context, *analysis) {
+	c.analysesMu.Lock()
+	defer c.analysesMu.Unlock()
+
+	if supersede {
+		for a := range c.analyses {
+			if a.documentID == documentID {
+				a.cancel(errAnalysisSuperseded)
+				delete(c.analyses, a)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(c.ctx)
+	a := &analysis{id: id, documentID: documentID, cancel: cancel}
+	c.analyses[a] = struct{}{}
+	return ctx, a
+}
+
+// finishAnalysis unregisters an analysis started with startAnalysis
+func (c *client) finishAnalysis(a *analysis) {
+	c.analysesMu.Lock()
+	defer c.analysesMu.Unlock()
+
+	delete(c.analyses, a)
+	a.cancel(nil)
+}
+
+// cancelAnalysis abandons the analysis requested with the ID, reporting
+// whether one was in progress
+func (c *client) cancelAnalysis(id string) bool {
+	c.analysesMu.Lock()
+	defer c.analysesMu.Unlock()
+
+	for a := range c.analyses {
+		if a.id == id {
+			a.cancel(errAnalysisCanceled)
+			delete(c.analyses, a)
+			return true
+		}
+	}
+	return false
+}
+
+// watchExpiry sends a "token_expiring" message the warning time before the
+// principal's credentials expire, and closes the connection when they do,
+// replacing the timers set for earlier credentials
+func (c *client) watchExpiry(warning time.Duration) {
+	c.expiryMu.Lock()
+	defer c.expiryMu.Unlock()
+
+	c.stopExpiryTimers()
+
+	userID, expiresAt := c.principal.UserID, c.principal.ExpiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+	until := time.Until(expiresAt)
+
+	c.expiryTimers = append(c.expiryTimers,
+		time.AfterFunc(max(until-warning, 0), func() {
+			c.Send(models.AuthMessage{Type: "token_expiring", ExpiresAt: expiresAt})
+		}),
+		time.AfterFunc(until, func() {
+			wsLogger.Info("Credentials of user %s expired, closing connection", userID)
+			c.closeWith(closeTokenExpired, "token expired")
+		}),
+	)
+}
+
+// stopExpiryTimers must be called with expiryMu held
+func (c *client) stopExpiryTimers() {
+	for _, timer := range c.expiryTimers {
+		timer.Stop()
+	}
+	c.expiryTimers = nil
+}
+
+// closeWith ends the connection with a close code telling the client why.
+// The read loop then fails and cleans up the connection.
+func (c *client) closeWith(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// defaultColor picks a stable colour for the user from presenceColors
+func defaultColor(userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return presenceColors[h.Sum32()%uint32(len(presenceColors))]
+}
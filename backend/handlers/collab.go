@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"codecollab/collab"
+	"codecollab/models"
+)
+
+const (
+	// maxDocumentIDLength bounds the document IDs clients can open rooms for
+	maxDocumentIDLength = 128
+
+	maxDisplayNameLength = 64
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// handleJoin adds the connection to a document room. The first member to
+// join a new document provides its language and initial content and owns it;
+// anyone else needs a role in it or a share link token.
+func handleJoin(c *client, request models.AnalyzeRequest) {
+	if request.DocumentID == "" || len(request.DocumentID) > maxDocumentIDLength {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Missing or invalid documentId field")
+		return
+	}
+
+	if _, joined := c.rooms[request.DocumentID]; joined {
+		sendErrorCode(c, request.ID, errorAlreadyJoined, "Already joined to document: "+request.DocumentID)
+		return
+	}
+
+	// A document that does not exist yet is created empty if no code is sent
+	var content string
+	if request.Code != nil {
+		content = *request.Code
+	}
+	if len([]rune(content)) > collab.MaxDocumentSize {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Document is too large")
+		return
+	}
+
+	if !updateIdentity(c, request) {
+		return
+	}
+
+	room, err := c.hub.Join(request.ID, request.DocumentID, request.Language, content, request.ShareToken, c)
+	if errors.Is(err, collab.ErrForbidden) {
+		wsLogger.Warn("Denied user %s access to document %s", c.principal.UserID, request.DocumentID)
+		sendErrorCode(c, request.ID, errorForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		wsLogger.Error("Failed to open document %s for user %s: %v", request.DocumentID, c.principal.UserID, err)
+		sendErrorCode(c, request.ID, errorFailed, "Failed to open document: "+request.DocumentID)
+		return
+	}
+	c.rooms[room.ID] = room
+
+	wsLogger.Info("User %s joined document %s", c.principal.UserID, room.ID)
+}
+
+// handleLeave removes the connection from a document room
+func handleLeave(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	delete(c.rooms, room.ID)
+	c.hub.Leave(room, c)
+	clearSelection(c, room.ID)
+
+	wsLogger.Info("User %s left document %s", c.principal.UserID, room.ID)
+	c.Send(models.DocumentMessage{
+		Type:       "left",
+		ID:         request.ID,
+		DocumentID: room.ID,
+	})
+}
+
+// handleEdit applies an edit made against the request's revision to the
+// shared document; the room acknowledges it and forwards it to the others
+func handleEdit(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if _, err := room.ApplyEdit(c, request.ID, request.Revision, request.Ops); err != nil {
+		wsLogger.Warn("Rejected edit from user %s to document %s: %v", c.principal.UserID, room.ID, err)
+		sendFailed(c, request.ID, "Failed to apply edit", err)
+	}
+}
+
+// handlePresence shares the connection's cursor and selection in a document,
+// and any change to its display name or colour, with the rest of the room
+func handlePresence(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if !updateIdentity(c, request) {
+		return
+	}
+
+	connectionsMu.Lock()
+	c.info.DocumentID = room.ID
+	c.info.Selection = request.Selection
+	connectionsMu.Unlock()
+
+	room.UpdatePresence(c, request.ID, request.Selection)
+}
+
+// updateIdentity applies the display name and colour from the request if
+// set, reporting false after sending an error when they are invalid
+func updateIdentity(c *client, request models.AnalyzeRequest) bool {
+	displayName := strings.TrimSpace(request.DisplayName)
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Display name is too long")
+		return false
+	}
+
+	if request.Color != "" && !colorPattern.MatchString(request.Color) {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Color must be in #rrggbb format")
+		return false
+	}
+
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if displayName != "" {
+		c.info.DisplayName = displayName
+	}
+	if request.Color != "" {
+		c.info.Color = request.Color
+	}
+	return true
+}
+
+// clearSelection forgets the connection's cursor if it was in the document
+func clearSelection(c *client, documentID string) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if c.info.DocumentID == documentID {
+		c.info.DocumentID = ""
+		c.info.Selection = nil
+	}
+}
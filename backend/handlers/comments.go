@@ -0,0 +1,53 @@
+package handlers
+
+import "codecollab/models"
+
+// handleComment starts a comment thread on request.Range of a joined document
+func handleComment(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if request.Range == nil {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Missing range field")
+		return
+	}
+
+	thread, err := room.AddThread(c, request.ID, *request.Range, request.Body)
+	if err != nil {
+		sendFailed(c, request.ID, "Failed to add comment", err)
+		return
+	}
+
+	wsLogger.Info("User %s started thread %s on document %s", c.principal.UserID, thread.ID, room.ID)
+}
+
+// handleReply adds a comment to a thread of a joined document
+func handleReply(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if _, err := room.Reply(c, request.ID, request.ThreadID, request.Body); err != nil {
+		sendFailed(c, request.ID, "Failed to reply", err)
+	}
+}
+
+// handleResolve resolves a thread of a joined document, or reopens it when
+// resolved is false
+func handleResolve(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	resolved := request.Resolved == nil || *request.Resolved
+	if _, err := room.Resolve(c, request.ID, request.ThreadID, resolved); err != nil {
+		sendFailed(c, request.ID, "Failed to resolve thread", err)
+	}
+}
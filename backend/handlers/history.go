@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"codecollab/auth"
+	"codecollab/collab"
+	"codecollab/models"
+	"codecollab/store"
+)
+
+const (
+	defaultRevisionLimit = 100
+	maxRevisionLimit     = 1000
+)
+
+// handleHistory lists revisions of a joined document from request.From
+func handleHistory(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if request.From < 0 || request.Limit < 0 {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "from and limit must not be negative")
+		return
+	}
+
+	revisions, current, err := c.hub.Revisions(c.ctx, room.ID, request.From, revisionLimit(request.Limit))
+	if err != nil {
+		wsLogger.Error("Failed to list revisions of document %s: %v", room.ID, err)
+		sendFailed(c, request.ID, "Failed to list revisions", err)
+		return
+	}
+
+	c.Send(models.HistoryMessage{
+		Type:       "history",
+		ID:         request.ID,
+		DocumentID: room.ID,
+		From:       request.From,
+		To:         current,
+		Revisions:  revisions,
+	})
+}
+
+// handleDiff compares two revisions of a joined document
+func handleDiff(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if request.From < 0 || request.To < 0 {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "from and to must not be negative")
+		return
+	}
+
+	ops, diff, err := c.hub.Diff(c.ctx, room.ID, request.From, request.To)
+	if err != nil {
+		wsLogger.Warn("Failed to diff document %s: %v", room.ID, err)
+		sendFailed(c, request.ID, "Failed to diff revisions", err)
+		return
+	}
+
+	c.Send(models.HistoryMessage{
+		Type:       "diff",
+		ID:         request.ID,
+		DocumentID: room.ID,
+		From:       request.From,
+		To:         request.To,
+		Ops:        ops,
+		Diff:       diff,
+	})
+}
+
+// handleRestore sets a joined document back to request.Revision. Members,
+// including the sender, receive the change as an edit before "restored".
+func handleRestore(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	revision, err := c.hub.Restore(context.Background(), room.ID, request.Revision, c.principal.UserID)
+	if err != nil {
+		wsLogger.Warn("Failed to restore document %s: %v", room.ID, err)
+		sendFailed(c, request.ID, "Failed to restore revision", err)
+		return
+	}
+
+	c.Send(models.DocumentMessage{
+		Type:       "restored",
+		ID:         request.ID,
+		DocumentID: room.ID,
+		Revision:   revision,
+	})
+}
+
+// HandleDocumentRevisions serves GET /api/v1/documents/{id}/revisions
+func HandleDocumentRevisions(authenticator auth.Authenticator, hub *collab.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok || !authorizeDocument(w, r, hub, principal, collab.PermView) {
+			return
+		}
+
+		from, ok := queryInt(w, r, "from", 0)
+		if !ok {
+			return
+		}
+		limit, ok := queryInt(w, r, "limit", defaultRevisionLimit)
+		if !ok {
+			return
+		}
+
+		documentID := r.PathValue("id")
+		revisions, current, err := hub.Revisions(r.Context(), documentID, from, revisionLimit(limit))
+		if err != nil {
+			writeHistoryError(w, documentID, err)
+			return
+		}
+
+		if revisions == nil {
+			revisions = []models.Revision{}
+		}
+		writeJSON(w, http.StatusOK, models.HistoryMessage{
+			Type:       "history",
+			DocumentID: documentID,
+			From:       from,
+			To:         current,
+			Revisions:  revisions,
+		})
+	}
+}
+
+// HandleDocumentDiff serves GET /api/v1/documents/{id}/diff?from=&to=
+func HandleDocumentDiff(authenticator auth.Authenticator, hub *collab.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok || !authorizeDocument(w, r, hub, principal, collab.PermView) {
+			return
+		}
+
+		if r.URL.Query().Get("from") == "" || r.URL.Query().Get("to") == "" {
+			writeAPIError(w, http.StatusBadRequest, "from and to revisions are required")
+			return
+		}
+		from, ok := queryInt(w, r, "from", 0)
+		if !ok {
+			return
+		}
+		to, ok := queryInt(w, r, "to", 0)
+		if !ok {
+			return
+		}
+
+		documentID := r.PathValue("id")
+		ops, diff, err := hub.Diff(r.Context(), documentID, from, to)
+		if err != nil {
+			writeHistoryError(w, documentID, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, models.HistoryMessage{
+			Type:       "diff",
+			DocumentID: documentID,
+			From:       from,
+			To:         to,
+			Ops:        ops,
+			Diff:       diff,
+		})
+	}
+}
+
+// HandleDocumentRestore serves POST /api/v1/documents/{id}/restore with a
+// body of {"revision": n}
+func HandleDocumentRestore(authenticator auth.Authenticator, hub *collab.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok || !authorizeDocument(w, r, hub, principal, collab.PermEdit) {
+			return
+		}
+
+		var body struct {
+			Revision *int `json:"revision"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Revision == nil {
+			writeAPIError(w, http.StatusBadRequest, "Body must be a JSON object with a revision")
+			return
+		}
+
+		documentID := r.PathValue("id")
+		revision, err := hub.Restore(r.Context(), documentID, *body.Revision, principal.UserID)
+		if err != nil {
+			writeHistoryError(w, documentID, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, models.DocumentMessage{
+			Type:       "restored",
+			DocumentID: documentID,
+			Revision:   revision,
+		})
+	}
+}
+
+func writeHistoryError(w http.ResponseWriter, documentID string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, "Document not found: "+documentID)
+	case errors.Is(err, collab.ErrForbidden):
+		writeAPIError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, collab.ErrUnknownRevision):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	default:
+		apiLogger.Error("Failed to read history of document %s: %v", documentID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to read document history")
+	}
+}
+
+// authorizeDocument checks that the user's role in the document, directly or
+// through the shareToken query parameter, allows the action, writing an
+// error response if not
+func authorizeDocument(w http.ResponseWriter, r *http.Request, hub *collab.Hub, principal *auth.Principal, p collab.Permission) bool {
+	documentID := r.PathValue("id")
+	if err := hub.Authorize(r.Context(), documentID, principal.UserID, r.URL.Query().Get("shareToken"), p); err != nil {
+		writeHistoryError(w, documentID, err)
+		return false
+	}
+	return true
+}
+
+// queryInt parses an optional non-negative integer query parameter, writing
+// a 400 response if it is malformed
+func queryInt(w http.ResponseWriter, r *http.Request, name string, defaultValue int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, true
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		writeAPIError(w, http.StatusBadRequest, "Invalid "+name+" parameter")
+		return 0, false
+	}
+	return n, true
+}
+
+func revisionLimit(limit int) int {
+	if limit <= 0 {
+		return defaultRevisionLimit
+	}
+	return min(limit, maxRevisionLimit)
+}
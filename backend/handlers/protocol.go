@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"codecollab/models"
+)
+
+// Versions of the WebSocket message format. Version 0 messages are flat
+// objects naming their action or type; version 1 messages are envelopes
+// with the rest of their fields in the payload.
+const (
+	protocolV0 = 0
+	protocolV1 = 1
+)
+
+var (
+	// errInvalidRequest is returned for messages that are not a request
+	errInvalidRequest = errors.New("invalid request")
+
+	// errUnsupportedVersion is returned for envelopes of a version the
+	// server does not speak
+	errUnsupportedVersion = errors.New("unsupported protocol version")
+)
+
+// protocolVersion returns the message format of a connection that
+// negotiated the subprotocol. Clients that offered none get version 0.
+func protocolVersion(subprotocol string) int {
+	if subprotocol == wsProtocolV1 {
+		return protocolV1
+	}
+	return protocolV0
+}
+
+// decodeRequest reads a request sent in a version 1 envelope or in the
+// version 0 shape, which every connection accepts so that old clients keep
+// working. The ID is returned with errors when it could be read.
+func decodeRequest(data []byte) (models.AnalyzeRequest, error) {
+	var request models.AnalyzeRequest
+
+	var envelope models.Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return request, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	request.ID = envelope.ID
+
+	switch envelope.V {
+	case protocolV0:
+		if err := json.Unmarshal(data, &request); err != nil {
+			return request, fmt.Errorf("%w: %v", errInvalidRequest, err)
+		}
+	case protocolV1:
+		if len(envelope.Payload) > 0 {
+			if err := json.Unmarshal(envelope.Payload, &request); err != nil {
+				return request, fmt.Errorf("%w: payload: %v", errInvalidRequest, err)
+			}
+		}
+		request.ID, request.Action = envelope.ID, envelope.Type
+	default:
+		return request, fmt.Errorf("%w: %d", errUnsupportedVersion, envelope.V)
+	}
+	return request, nil
+}
+
+// encodeMessage marshals a message in the version's format. Messages are
+// flat structs with a type and, when they answer a request, its ID; in
+// version 1 their other fields move to the envelope's payload.
+func encodeMessage(message interface{}, version int) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil || version == protocolV0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	envelope := models.Envelope{V: version}
+	if raw, found := fields["type"]; found {
+		if err := json.Unmarshal(raw, &envelope.Type); err != nil {
+			return nil, err
+		}
+	}
+	if raw, found := fields["id"]; found {
+		if err := json.Unmarshal(raw, &envelope.ID); err != nil {
+			return nil, err
+		}
+	}
+	delete(fields, "type")
+	delete(fields, "id")
+
+	if envelope.Payload, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/collab"
+	"codecollab/models"
+)
+
+const (
+	// replayPageSize is how many revisions are loaded at a time while replaying
+	replayPageSize = 500
+
+	// replayWriteTimeout replaces the server's write timeout for each event,
+	// since a replay at original speed can run far longer than a request
+	replayWriteTimeout = 15 * time.Second
+)
+
+// replayer writes a document's history as server-sent events, waiting
+// between events as long as the original session did divided by speed
+type replayer struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	speed    float64
+	maxDelay time.Duration
+	last     time.Time
+}
+
+// HandleDocumentReplay serves GET /api/v1/documents/{id}/replay, streaming
+// the document at revision from, then every edit up to revision to and the
+// analysis recorded for each revision as server-sent events
+func HandleDocumentReplay(authenticator auth.Authenticator, hub *collab.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok || !authorizeDocument(w, r, hub, principal, collab.PermView) {
+			return
+		}
+
+		from, ok := queryInt(w, r, "from", 0)
+		if !ok {
+			return
+		}
+		to, ok := queryInt(w, r, "to", -1)
+		if !ok {
+			return
+		}
+
+		speed := 1.0
+		if value := r.URL.Query().Get("speed"); value != "" {
+			var err error
+			if speed, err = strconv.ParseFloat(value, 64); err != nil || speed < 0 || math.IsInf(speed, 0) {
+				writeAPIError(w, http.StatusBadRequest, "Invalid speed parameter")
+				return
+			}
+		}
+
+		var maxDelay time.Duration
+		if value := r.URL.Query().Get("maxDelay"); value != "" {
+			var err error
+			if maxDelay, err = time.ParseDuration(value); err != nil || maxDelay < 0 {
+				writeAPIError(w, http.StatusBadRequest, "Invalid maxDelay parameter")
+				return
+			}
+		}
+
+		ctx := r.Context()
+		documentID := r.PathValue("id")
+
+		content, err := hub.ContentAt(ctx, documentID, from)
+		if err != nil {
+			writeHistoryError(w, documentID, err)
+			return
+		}
+		// An empty page still reports the current revision
+		_, current, err := hub.Revisions(ctx, documentID, from, 0)
+		if err != nil {
+			writeHistoryError(w, documentID, err)
+			return
+		}
+		if to == -1 {
+			to = current
+		}
+		if to < from || to > current {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("to must be between %d and %d", from, current))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		p := &replayer{
+			w:        w,
+			rc:       http.NewResponseController(w),
+			speed:    speed,
+			maxDelay: maxDelay,
+		}
+		if err := p.replay(ctx, hub, documentID, content, from, to); err != nil && ctx.Err() == nil {
+			apiLogger.Error("Replay of document %s stopped: %v", documentID, err)
+			p.send("error", map[string]string{"message": err.Error()})
+		}
+	}
+}
+
+func (p *replayer) replay(ctx context.Context, hub *collab.Hub, documentID, content string, from, to int) error {
+	err := p.send("snapshot", models.DocumentMessage{
+		Type:       "snapshot",
+		DocumentID: documentID,
+		Content:    content,
+		Revision:   from,
+	})
+	if err != nil {
+		return err
+	}
+
+	lints, err := hub.LintResults(ctx, documentID, from, from)
+	if err != nil {
+		return err
+	}
+	for _, lint := range lints {
+		if err := p.send("lint", lint); err != nil {
+			return err
+		}
+	}
+
+	for next := from + 1; next <= to; {
+		revisions, _, err := hub.Revisions(ctx, documentID, next, min(replayPageSize, to-next+1))
+		if err != nil {
+			return err
+		}
+		if len(revisions) == 0 {
+			return fmt.Errorf("history of revision %d is missing", next)
+		}
+		last := revisions[len(revisions)-1].Revision
+
+		lints, err := hub.LintResults(ctx, documentID, next, last)
+		if err != nil {
+			return err
+		}
+
+		for _, revision := range revisions {
+			if err := p.wait(ctx, revision.Timestamp); err != nil {
+				return err
+			}
+			if err := p.send("edit", revision); err != nil {
+				return err
+			}
+
+			for len(lints) > 0 && lints[0].Revision <= revision.Revision {
+				lint := lints[0]
+				lints = lints[1:]
+				if lint.Revision < revision.Revision {
+					continue
+				}
+				if err := p.wait(ctx, lint.Timestamp); err != nil {
+					return err
+				}
+				if err := p.send("lint", lint); err != nil {
+					return err
+				}
+			}
+		}
+
+		next = last + 1
+	}
+
+	return p.send("end", models.DocumentMessage{
+		Type:       "end",
+		DocumentID: documentID,
+		Revision:   to,
+	})
+}
+
+// wait sleeps for the time between the previous event and one at the given
+// time, scaled by the replay speed. A speed of 0 replays without waiting.
+func (p *replayer) wait(ctx context.Context, at time.Time) error {
+	previous := p.last
+	if at.After(p.last) {
+		p.last = at
+	}
+	if previous.IsZero() || p.speed == 0 {
+		return nil
+	}
+
+	delay := time.Duration(float64(at.Sub(previous)) / p.speed)
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *replayer) send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	p.rc.SetWriteDeadline(time.Now().Add(replayWriteTimeout))
+	if _, err := fmt.Fprintf(p.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return p.rc.Flush()
+}
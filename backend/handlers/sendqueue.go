@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"codecollab/metrics"
+	"codecollab/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errSlowConsumer is returned for messages to a connection whose queue is
+// full of messages that cannot be dropped
+var errSlowConsumer = errors.New("slow consumer")
+
+// outbound is an encoded message waiting to be written
+type outbound struct {
+	data []byte
+	// presence updates are the first dropped when the queue is full
+	presence bool
+	// key identifies the messages a newer one with the same key replaces
+	// while they are still queued, empty if none
+	key string
+}
+
+// newOutbound classifies a message for the queue. Presence updates replace
+// the member's earlier ones and lint results broadcast for a document
+// replace its earlier ones; results answering a request are always kept.
+func newOutbound(message interface{}, data []byte) outbound {
+	o := outbound{data: data}
+	switch m := message.(type) {
+	case models.DocumentMessage:
+		if m.Type == "presence" {
+			o.presence = true
+			o.key = "presence:" + m.DocumentID + ":" + m.SessionID
+		}
+	case models.AnalyzeResponse:
+		if m.Type == "analysis_result" && m.ID == "" && m.DocumentID != "" {
+			o.key = "analysis:" + m.DocumentID
+		}
+	}
+	return o
+}
+
+// sendQueue holds a connection's messages until its write pump writes them.
+// It is bounded so that a client reading slowly cannot hold up the rooms
+// broadcasting to it: once full it drops presence updates, and when only
+// messages the client cannot do without are left it takes them for a grace
+// period, up to twice its size, so that a brief stall does not disconnect
+// the client. If it is still full after that it refuses more.
+type sendQueue struct {
+	mu       sync.Mutex
+	messages []outbound
+	size     int
+	closed   bool
+
+	grace time.Duration
+	// fullSince is when the queue last filled with messages it cannot drop,
+	// zero while it has room
+	fullSince time.Time
+
+	// ready holds a token while messages are queued, waking the write pump
+	ready chan struct{}
+
+	sessionID string
+	depth     prometheus.Gauge
+}
+
+func newSendQueue(size int, grace time.Duration, sessionID string) *sendQueue {
+	return &sendQueue{
+		size:      max(size, 1),
+		grace:     grace,
+		ready:     make(chan struct{}, 1),
+		sessionID: sessionID,
+		depth:     metrics.WebSocketSendQueueDepth.WithLabelValues(sessionID),
+	}
+}
+
+// push queues a message. If the queue has been full for longer than the
+// grace period it returns errSlowConsumer and closes, returning
+// errConnectionClosed from then on.
+func (q *sendQueue) push(o outbound) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errConnectionClosed
+	}
+
+	// A replaced message moves to the back, so it still follows the
+	// messages queued before the newer one
+	if o.key != "" {
+		for i, queued := range q.messages {
+			if queued.key == o.key {
+				q.remove(i)
+				metrics.WebSocketMessagesDropped.WithLabelValues("coalesced").Inc()
+				break
+			}
+		}
+	}
+
+	if len(q.messages) >= q.size {
+		if o.presence {
+			metrics.WebSocketMessagesDropped.WithLabelValues("presence").Inc()
+			return nil
+		}
+		if i := q.indexOfPresence(); i >= 0 {
+			q.remove(i)
+			metrics.WebSocketMessagesDropped.WithLabelValues("presence").Inc()
+		} else {
+			now := time.Now()
+			if q.fullSince.IsZero() {
+				q.fullSince = now
+			}
+			if now.Sub(q.fullSince) >= q.grace || len(q.messages) >= 2*q.size {
+				q.closed = true
+				return errSlowConsumer
+			}
+		}
+	}
+
+	q.messages = append(q.messages, o)
+	q.depth.Set(float64(len(q.messages)))
+	q.signal()
+	return nil
+}
+
+// pop takes the oldest message, leaving the queue ready if more remain
+func (q *sendQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil, false
+	}
+	o := q.messages[0]
+	q.messages[0] = outbound{}
+	q.messages = q.messages[1:]
+	q.depth.Set(float64(len(q.messages)))
+	if len(q.messages) < q.size {
+		q.fullSince = time.Time{}
+	}
+
+	if len(q.messages) > 0 {
+		q.signal()
+	}
+	return o.data, true
+}
+
+// close discards the queued messages and refuses further ones
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.messages = nil
+	metrics.WebSocketSendQueueDepth.DeleteLabelValues(q.sessionID)
+}
+
+// remove must be called with the lock held
+func (q *sendQueue) remove(i int) {
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+}
+
+// indexOfPresence must be called with the lock held
+func (q *sendQueue) indexOfPresence() int {
+	for i, queued := range q.messages {
+		if queued.presence {
+			return i
+		}
+	}
+	return -1
+}
+
+// signal must be called with the lock held
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
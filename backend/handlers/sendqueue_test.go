@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"codecollab/metrics"
+	"codecollab/models"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func message(data string) outbound {
+	return outbound{data: []byte(data)}
+}
+
+func presence(sessionID, data string) outbound {
+	return newOutbound(models.DocumentMessage{Type: "presence", DocumentID: "doc", SessionID: sessionID}, []byte(data))
+}
+
+// drain pops every queued message
+func drain(q *sendQueue) []string {
+	var messages []string
+	for {
+		data, ok := q.pop()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, string(data))
+	}
+}
+
+func depth(t *testing.T, q *sendQueue) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := q.depth.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSendQueueDropsPresenceWhenFull(t *testing.T) {
+	q := newSendQueue(3, 0, t.Name())
+	defer q.close()
+
+	for _, o := range []outbound{message("a"), presence("s1", "p1"), message("b")} {
+		if err := q.push(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The queued presence update makes room for a message that matters
+	if err := q.push(message("c")); err != nil {
+		t.Fatalf("push with a presence update queued: %v", err)
+	}
+	// and a new one is dropped rather than queued
+	if err := q.push(presence("s2", "p2")); err != nil {
+		t.Fatalf("push of a presence update to a full queue: %v", err)
+	}
+
+	if got := depth(t, q); got != 3 {
+		t.Errorf("depth = %v, want 3", got)
+	}
+	if got, want := drain(q), []string{"a", "b", "c"}; !equal(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+	if got := depth(t, q); got != 0 {
+		t.Errorf("depth after draining = %v, want 0", got)
+	}
+}
+
+func TestSendQueueCoalesces(t *testing.T) {
+	q := newSendQueue(10, 0, t.Name())
+	defer q.close()
+
+	analysis := func(documentID, id, data string) outbound {
+		return newOutbound(models.AnalyzeResponse{Type: "analysis_result", ID: id, DocumentID: documentID}, []byte(data))
+	}
+	for _, o := range []outbound{
+		presence("s1", "p1"),
+		analysis("doc", "", "lint1"),
+		message("edit"),
+		presence("s2", "q1"),
+		presence("s1", "p2"),
+		analysis("doc", "", "lint2"),
+		// Results answering a request are always kept
+		analysis("doc", "7", "answer"),
+		analysis("other", "", "lint3"),
+	} {
+		if err := q.push(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"edit", "q1", "p2", "lint2", "answer", "lint3"}
+	if got := drain(q); !equal(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+}
+
+func TestSendQueueGracePeriod(t *testing.T) {
+	q := newSendQueue(2, time.Minute, t.Name())
+	defer q.close()
+
+	for _, data := range []string{"a", "b", "c"} {
+		if err := q.push(message(data)); err != nil {
+			t.Fatalf("push of %s within the grace period: %v", data, err)
+		}
+	}
+
+	// Catching up below the size ends the grace period
+	drain(q)
+	if !q.fullSince.IsZero() {
+		t.Fatal("grace period still running after the queue drained")
+	}
+
+	for _, data := range []string{"d", "e", "f"} {
+		if err := q.push(message(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.fullSince = time.Now().Add(-time.Minute)
+	if err := q.push(message("g")); !errors.Is(err, errSlowConsumer) {
+		t.Fatalf("push after the grace period = %v, want errSlowConsumer", err)
+	}
+	if err := q.push(message("h")); !errors.Is(err, errConnectionClosed) {
+		t.Fatalf("push after closing = %v, want errConnectionClosed", err)
+	}
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	t.Run("without grace period", func(t *testing.T) {
+		q := newSendQueue(2, 0, t.Name())
+		defer q.close()
+
+		q.push(message("a"))
+		q.push(message("b"))
+		if err := q.push(message("c")); !errors.Is(err, errSlowConsumer) {
+			t.Fatalf("push to a full queue = %v, want errSlowConsumer", err)
+		}
+	})
+
+	t.Run("twice the size", func(t *testing.T) {
+		q := newSendQueue(2, time.Hour, t.Name())
+		defer q.close()
+
+		for i := 0; i < 4; i++ {
+			if err := q.push(message("m")); err != nil {
+				t.Fatalf("push %d: %v", i, err)
+			}
+		}
+		if err := q.push(message("m")); !errors.Is(err, errSlowConsumer) {
+			t.Fatalf("push beyond twice the size = %v, want errSlowConsumer", err)
+		}
+	})
+}
+
+func TestSendQueueCloseDeletesDepth(t *testing.T) {
+	q := newSendQueue(2, 0, t.Name())
+	q.push(message("a"))
+	q.close()
+
+	if metrics.WebSocketSendQueueDepth.DeleteLabelValues(t.Name()) {
+		t.Error("depth of a closed queue is still reported")
+	}
+	if _, ok := q.pop(); ok {
+		t.Error("closed queue still has messages")
+	}
+}
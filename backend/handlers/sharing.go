@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"time"
+
+	"codecollab/collab"
+	"codecollab/config"
+	"codecollab/models"
+)
+
+// handleSharing sends the owner who has access to a joined document
+func handleSharing(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	sendSharing(c, request.ID, room)
+}
+
+// handleShare gives request.UserID request.Role in a joined document, or
+// removes their access when the role is empty
+func handleShare(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if err := room.Share(request.UserID, request.Role); err != nil {
+		sendFailed(c, request.ID, "Failed to share document", err)
+		return
+	}
+
+	wsLogger.Info("User %s gave user %s role %q in document %s", c.principal.UserID, request.UserID, request.Role, room.ID)
+	sendSharing(c, request.ID, room)
+}
+
+// handleCreateLink creates a share link granting request.Role, expiring
+// after request.ExpiresIn seconds or the configured default
+func handleCreateLink(c *client, request models.AnalyzeRequest, cfg *config.Config) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if !collab.ValidSharedRole(request.Role) {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Role must be editor, commenter or viewer")
+		return
+	}
+
+	ttl := cfg.ShareLinkTTL
+	if ttl > cfg.ShareLinkMaxTTL {
+		ttl = cfg.ShareLinkMaxTTL
+	}
+	if request.ExpiresIn != 0 {
+		if request.ExpiresIn < 0 || request.ExpiresIn > int(cfg.ShareLinkMaxTTL/time.Second) {
+			sendErrorCode(c, request.ID, errorInvalidRequest, "Share links must expire within "+cfg.ShareLinkMaxTTL.String())
+			return
+		}
+		ttl = time.Duration(request.ExpiresIn) * time.Second
+	}
+
+	link, err := room.CreateShareLink(c.principal.UserID, request.Role, time.Now().Add(ttl))
+	if err != nil {
+		sendFailed(c, request.ID, "Failed to create share link", err)
+		return
+	}
+
+	wsLogger.Info("User %s created a %s share link for document %s, expiring %s", c.principal.UserID, link.Role, room.ID, link.ExpiresAt.Format(time.RFC3339))
+	sendSharing(c, request.ID, room)
+}
+
+// handleRevokeLink deletes the share link with token request.ShareToken
+func handleRevokeLink(c *client, request models.AnalyzeRequest) {
+	room, joined := c.rooms[request.DocumentID]
+	if !joined {
+		sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+		return
+	}
+
+	if err := room.RevokeShareLink(request.ShareToken); err != nil {
+		sendFailed(c, request.ID, "Failed to revoke share link", err)
+		return
+	}
+
+	wsLogger.Info("User %s revoked a share link for document %s", c.principal.UserID, room.ID)
+	sendSharing(c, request.ID, room)
+}
+
+// sendSharing answers the request with who has access to the document
+func sendSharing(c *client, id string, room *collab.Room) {
+	sharing := room.Sharing()
+	sharing.ID = id
+	c.Send(sharing)
+}
@@ -1,11 +1,19 @@
 package handlers
 
 import (
+	"embed"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 )
 
+// schemas are the JSON Schemas of the WebSocket protocol, built into the
+// binary so they are served wherever it runs
+//
+//go:embed schemas/*.json
+var schemas embed.FS
+
 // ServeSwaggerYAML serves the swagger.yaml file
 func ServeSwaggerYAML(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-yaml")
@@ -87,3 +95,32 @@ func ServeSwaggerUI(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(html))
 }
+
+// ServeSchema serves the JSON Schemas of the WebSocket protocol under
+// /schemas/{name}
+func ServeSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name := r.PathValue("name")
+	if path.Base(name) != name || path.Ext(name) != ".json" {
+		http.Error(w, "Schema not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := schemas.ReadFile(path.Join("schemas", name))
+	if err != nil {
+		http.Error(w, "Schema not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
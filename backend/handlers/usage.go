@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"codecollab/auth"
+	"codecollab/middleware"
+)
+
+// HandleUsage serves GET /api/v1/usage, the caller's linter use today and
+// this month and what is left of their quotas and their tenant's
+func HandleUsage(authenticator auth.Authenticator, quotas *middleware.Quotas) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		principal, ok := authenticateRequest(w, r, authenticator)
+		if !ok {
+			return
+		}
+
+		report, err := quotas.Report(r.Context(), principal)
+		if err != nil {
+			apiLogger.Error("Failed to report usage of user %s: %v", principal.UserID, err)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to read usage")
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
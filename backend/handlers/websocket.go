@@ -1,19 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
     "codecollab/middleware"
 	"codecollab/models"
 	"codecollab/utils"
 	"codecollab/config"
+	"codecollab/linter"
+	"codecollab/collab"
+	"codecollab/auth"
+	"codecollab/store"
 	"github.com/gorilla/websocket"
 )
 
 var (
-	connections   = make(map[*websocket.Conn]*models.Connection)
+	connections   = make(map[*websocket.Conn]*client)
 	connectionsMu sync.RWMutex
 	upgrader      = websocket.Upgrader{
 		ReadBufferSize:  1024,
@@ -22,161 +31,548 @@ var (
 
 			return true
 		},
+		Subprotocols: []string{wsProtocolV1, wsProtocol},
 	}
 	wsLogger    = utils.NewLogger("websocket")
-	rateLimiter = middleware.NewRateLimiter(60, 1*time.Minute)
 )
 
-func HandleWebSocket(cfg *config.Config) http.HandlerFunc {
+const (
+	// errorForbidden is the code of errors for actions the user's role in a
+	// document does not allow
+	errorForbidden = "forbidden"
+
+	// errorUnauthorized is the code of errors for credentials that were
+	// rejected on reauthentication
+	errorUnauthorized = "unauthorized"
+
+	// errorRateLimited is the code of errors for requests over the user's
+	// rate limit, sent with how long to wait
+	errorRateLimited = "rate_limited"
+
+	// errorQuotaExceeded is the code of errors for analyses over a quota of
+	// the user or their tenant, sent with how long until it resets
+	errorQuotaExceeded = "quota_exceeded"
+
+	// errorTooManyRequests is the code of errors for analyses sent while the
+	// connection already has as many in progress as allowed
+	errorTooManyRequests = "too_many_requests"
+
+	// errorCanceled and errorSuperseded are the codes of errors answering
+	// analyses abandoned by a cancel request or a newer analysis
+	errorCanceled   = "canceled"
+	errorSuperseded = "superseded"
+
+	// errorInvalidRequest is the code of errors for messages that cannot be
+	// read or are missing fields or have invalid ones, and
+	// errorUnsupportedVersion of envelopes of an unknown protocol version
+	errorInvalidRequest     = "invalid_request"
+	errorUnsupportedVersion = "unsupported_version"
+
+	// errorUnknownAction is the code of errors for actions the server does
+	// not know
+	errorUnknownAction = "unknown_action"
+
+	// errorNotJoined and errorAlreadyJoined are the codes of errors for
+	// actions on a document the connection has not joined, or for joining
+	// one twice
+	errorNotJoined     = "not_joined"
+	errorAlreadyJoined = "already_joined"
+
+	// errorNotFound is the code of errors for a revision, comment thread or
+	// analysis that does not exist
+	errorNotFound = "not_found"
+
+	// errorStaleRevision is the code of errors for edits based on a revision
+	// too old to transform them against
+	errorStaleRevision = "stale_revision"
+
+	// errorUnsupportedLanguage and errorLintFailed are the codes of errors
+	// for analyses of a language without a linter, or whose linter failed
+	errorUnsupportedLanguage = "unsupported_language"
+	errorLintFailed          = "lint_failed"
+
+	// errorFailed is the code of errors for actions that failed for any
+	// other reason, given in the message
+	errorFailed = "failed"
+)
+
+// Close codes telling clients why the server ended the connection
+const (
+	closeTokenExpired = 4001
+	closeIdle         = 4002
+	closeRevoked      = 4003
+	closeSlowConsumer = 4004
+)
+
+// documentPermissions is what each action needs the user's role in the
+// document it names to allow. Join checks access itself and leave needs none.
+var documentPermissions = map[string]collab.Permission{
+	"analyze":     collab.PermView,
+	"presence":    collab.PermView,
+	"history":     collab.PermView,
+	"diff":        collab.PermView,
+	"comment":     collab.PermComment,
+	"reply":       collab.PermComment,
+	"resolve":     collab.PermComment,
+	"edit":        collab.PermEdit,
+	"restore":     collab.PermEdit,
+	"sharing":     collab.PermShare,
+	"share":       collab.PermShare,
+	"create_link": collab.PermShare,
+	"revoke_link": collab.PermShare,
+}
+
+func HandleWebSocket(cfg *config.Config, authenticator auth.Authenticator, tickets *auth.TicketStore, revocations *auth.Revocations, guard *middleware.ConnectionGuard, limiter *middleware.RateLimiter, quotas *middleware.Quotas, linters *linter.Registry, hub *collab.Hub) http.HandlerFunc {
+	revocations.OnRevoke(disconnectRevoked)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token := r.URL.Query().Get("token")
-		if token == "" {
+		// Refuse banned and overeager clients before verifying anything
+		ip := guard.ClientIP(r)
+		if allowed, retryAfter := guard.Admit(r.Context(), ip); !allowed {
+			wsLogger.Warn("Refused WebSocket connection attempt from %s", ip)
+			writeTooManyRequests(w, retryAfter, "Too many connection attempts")
+			return
+		}
+
+		principal, err := authenticateWebSocket(r, cfg, authenticator, tickets, revocations)
+		if errors.Is(err, auth.ErrMissingToken) {
 			wsLogger.Error("Missing auth token in WebSocket request")
 			http.Error(w, "Missing auth token", http.StatusUnauthorized)
 			return
 		}
-
-		userID, err := VerifyToken(token, cfg)
 		if err != nil {
-			wsLogger.Error("Failed to verify token: %v", err)
+			guard.AuthFailed(ip)
+			wsLogger.Error("Failed to verify token from %s: %v", ip, err)
 			http.Error(w, "Invalid auth token", http.StatusUnauthorized)
 			return
 		}
 
+		if err := guard.Acquire(principal.UserID, ip); err != nil {
+			wsLogger.Warn("Refused WebSocket connection: %v", err)
+			writeTooManyRequests(w, 0, "Too many open connections")
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
+			guard.Release(principal.UserID, ip)
 			wsLogger.Error("Failed to upgrade connection: %v", err)
 			return
 		}
 
+		c := newClient(conn, principal, ip, hub, cfg)
+		go c.writePump()
+
 		connectionsMu.Lock()
-		connections[conn] = &models.Connection{
-			UserID:   userID,
-			LastSeen: time.Now(),
-		}
+		connections[conn] = c
 		connectionsMu.Unlock()
 
-		utils.LogConnection("connected", userID)
-		wsLogger.Info("New WebSocket connection for user: %s", userID)
+		c.watchExpiry(cfg.TokenExpiryWarning)
+
+		utils.LogConnection("connected", principal.UserID)
+		wsLogger.Info("New WebSocket connection for user: %s", principal.UserID)
 
-		go handleConnection(conn, userID, cfg)
+		go handleConnection(c, cfg, authenticator, guard, limiter, quotas, linters)
 	}
 }
 
-func handleConnection(conn *websocket.Conn, userID string, cfg *config.Config) {
+func handleConnection(c *client, cfg *config.Config, authenticator auth.Authenticator, guard *middleware.ConnectionGuard, limiter *middleware.RateLimiter, quotas *middleware.Quotas, linters *linter.Registry) {
+	conn, userID := c.conn, c.principal.UserID
+
 	defer func() {
+		c.cancel()
+		c.send.close()
+
+		c.expiryMu.Lock()
+		c.stopExpiryTimers()
+		c.expiryMu.Unlock()
+
+		for _, room := range c.rooms {
+			c.hub.Leave(room, c)
+		}
 
 		connectionsMu.Lock()
 		delete(connections, conn)
 		connectionsMu.Unlock()
+		guard.Release(userID, c.ip)
 
 		conn.Close()
 		utils.LogConnection("disconnected", userID)
 		wsLogger.Info("WebSocket connection closed for user: %s", userID)
 	}()
 
+	// A peer that answers neither pings nor sends anything for the pong
+	// timeout is gone
+	conn.SetReadDeadline(deadline(cfg.WSPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(deadline(cfg.WSPongTimeout))
+	})
+
 	for {
 
 		_, messageBytes, err := conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				wsLogger.Info("WebSocket connection of user %s timed out", userID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				wsLogger.Error("WebSocket error for user %s: %v", userID, err)
 			}
 			break
 		}
+		conn.SetReadDeadline(deadline(cfg.WSPongTimeout))
 
-		var request models.AnalyzeRequest
-		if err := json.Unmarshal(messageBytes, &request); err != nil {
+		request, err := decodeRequest(messageBytes)
+		if errors.Is(err, errUnsupportedVersion) {
+			sendErrorCode(c, request.ID, errorUnsupportedVersion, "Unsupported protocol version")
+			continue
+		}
+		if err != nil {
 			wsLogger.Error("Failed to parse request from user %s: %v", userID, err)
-			sendError(conn, "Invalid request format")
+			sendErrorCode(c, request.ID, errorInvalidRequest, "Invalid request format")
 			continue
 		}
 
-		if request.Action != "analyze" {
-			sendError(conn, "Unknown action: "+request.Action)
+		if allowed, retryAfter := limiter.Allow(c.ctx, c.principal, request.Action); !allowed {
+			wsLogger.Warn("Rate limit exceeded for user %s on plan %s: %s", userID, limiter.Plan(c.principal), request.Action)
+			sendRateLimited(c, request.ID, request.Action, retryAfter)
 			continue
 		}
 
-		if request.Language == "" {
-			sendError(conn, "Missing language field")
-			continue
+		// Rooms the connection was removed from on losing access are only
+		// forgotten here, as c.rooms belongs to the read loop
+		if room, joined := c.rooms[request.DocumentID]; joined && !room.Has(c) {
+			delete(c.rooms, room.ID)
 		}
 
-		if request.Code == "" {
-			sendError(conn, "Missing code field")
-			continue
+		if permission, scoped := documentPermissions[request.Action]; scoped {
+			if room, joined := c.rooms[request.DocumentID]; joined {
+				if err := room.Authorize(c, permission); err != nil {
+					wsLogger.Warn("Denied %s by user %s: %v", request.Action, userID, err)
+					sendErrorCode(c, request.ID, errorForbidden, err.Error())
+					continue
+				}
+			}
 		}
 
-		if !rateLimiter.CheckRateLimit(userID) {
-			wsLogger.Warn("Rate limit exceeded for user: %s", userID)
-			sendError(conn, "Rate limit exceeded. Please wait before sending more requests.")
+		switch request.Action {
+		case "analyze":
+			handleAnalyze(c, request, quotas, linters)
+		case "join":
+			handleJoin(c, request)
+		case "leave":
+			handleLeave(c, request)
+		case "edit":
+			handleEdit(c, request)
+		case "presence":
+			handlePresence(c, request)
+		case "history":
+			handleHistory(c, request)
+		case "diff":
+			handleDiff(c, request)
+		case "restore":
+			handleRestore(c, request)
+		case "comment":
+			handleComment(c, request)
+		case "reply":
+			handleReply(c, request)
+		case "resolve":
+			handleResolve(c, request)
+		case "sharing":
+			handleSharing(c, request)
+		case "share":
+			handleShare(c, request)
+		case "create_link":
+			handleCreateLink(c, request, cfg)
+		case "revoke_link":
+			handleRevokeLink(c, request)
+		case "reauth":
+			handleReauth(c, request, cfg, authenticator)
+		case "cancel":
+			handleCancel(c, request)
+		default:
+			sendErrorCode(c, request.ID, errorUnknownAction, "Unknown action: "+request.Action)
 			continue
 		}
 
-		startTime := time.Now()
-		wsLogger.Info("Processing analysis request from user %s for language: %s", userID, request.Language)
+		connectionsMu.Lock()
+		c.info.LastSeen = time.Now()
+		connectionsMu.Unlock()
+	}
+}
 
-		errors, err := InvokeLinter(request.Language, request.Code, cfg)
-		if err != nil {
-			wsLogger.Error("Failed to invoke linter for user %s: %v", userID, err)
-			sendError(conn, "Failed to analyze code: "+err.Error())
-			continue
+// handleAnalyze lints the code in the request, or the shared text of a joined
+// document in which case the result goes to everyone in the room. The
+// analysis counts against the quotas of the user and their tenant. Linting
+// runs alongside the connection's later requests, so its result may arrive
+// after theirs; clients match it to the request by ID.
+func handleAnalyze(c *client, request models.AnalyzeRequest, quotas *middleware.Quotas, linters *linter.Registry) {
+	var room *collab.Room
+	if request.DocumentID != "" {
+		var joined bool
+		if room, joined = c.rooms[request.DocumentID]; !joined {
+			sendErrorCode(c, request.ID, errorNotJoined, "Not joined to document: "+request.DocumentID)
+			return
+		}
+
+		var content string
+		request.Language, content, request.Revision = room.Snapshot()
+		if request.Code == nil {
+			request.Code = &content
+		} else {
+			// Code sent with the document ID is the client's own version
+			// of it, whose result is not shared with the room
+			room = nil
 		}
+	}
+
+	if request.Language == "" {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Missing language field")
+		return
+	}
+
+	if request.Code == nil {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Missing code field")
+		return
+	}
+
+	if _, err := linters.Get(request.Language); err != nil {
+		sendErrorCode(c, request.ID, errorUnsupportedLanguage, "Failed to analyze code: "+err.Error())
+		return
+	}
+
+	// The principal is replaced on reauthentication, so the analysis keeps
+	// the one it was requested by
+	principal := c.principal
+
+	var exceeded *middleware.QuotaExceededError
+	if err := quotas.Check(c.ctx, principal, len(*request.Code)); errors.As(err, &exceeded) {
+		wsLogger.Warn("Quota exceeded for user %s: %v", principal.UserID, err)
+		sendQuotaExceeded(c, request.ID, exceeded)
+		return
+	}
 
-		executionTime := int(time.Since(startTime).Milliseconds())
+	// The slot is taken first, so a request refused for lack of one does not
+	// cancel the analyses it would have superseded
+	if !c.startRequest() {
+		sendErrorCode(c, request.ID, errorTooManyRequests, "Too many requests in progress")
+		return
+	}
+	ctx, a := c.startAnalysis(request.ID, request.DocumentID, request.Supersede)
+	go func() {
+		defer c.finishRequest()
+		defer c.finishAnalysis(a)
+		analyze(ctx, c, principal, room, request, quotas, linters)
+	}()
+}
+
+// analyze runs the linter for handleAnalyze and sends the result, unless
+// the analysis is abandoned first
+func analyze(ctx context.Context, c *client, principal *auth.Principal, room *collab.Room, request models.AnalyzeRequest, quotas *middleware.Quotas, linters *linter.Registry) {
+	startTime := time.Now()
+	wsLogger.Info("Processing analysis request from user %s for language: %s", principal.UserID, request.Language)
 
-		response := models.AnalyzeResponse{
-			Type:          "analysis_result",
+	errors, err := linters.Lint(ctx, request.Language, *request.Code)
+	// The linter's time is spent even if the result is no longer wanted
+	if err := quotas.Record(context.Background(), principal, len(*request.Code), time.Since(startTime)); err != nil {
+		wsLogger.Error("Failed to record usage of user %s: %v", principal.UserID, err)
+	}
+	if ctx.Err() != nil {
+		// A stale result must not overwrite a newer one
+		switch context.Cause(ctx) {
+		case errAnalysisCanceled:
+			sendErrorCode(c, request.ID, errorCanceled, "Analysis canceled")
+		case errAnalysisSuperseded:
+			sendErrorCode(c, request.ID, errorSuperseded, "Analysis superseded by a newer one")
+		}
+		wsLogger.Info("Abandoned analysis for user %s: %v", principal.UserID, context.Cause(ctx))
+		return
+	}
+	if err != nil {
+		wsLogger.Error("Failed to invoke linter for user %s: %v", principal.UserID, err)
+		sendErrorCode(c, request.ID, errorLintFailed, "Failed to analyze code: "+err.Error())
+		return
+	}
+
+	executionTime := int(time.Since(startTime).Milliseconds())
+
+	response := models.AnalyzeResponse{
+		Type:          "analysis_result",
+		DocumentID:    request.DocumentID,
+		Errors:        errors,
+		ExecutionTime: executionTime,
+	}
+
+	if room != nil {
+		// Keep the result for replaying how the document evolved
+		err := c.hub.RecordLint(context.Background(), room.ID, &models.LintResult{
+			Revision:      request.Revision,
 			Errors:        errors,
 			ExecutionTime: executionTime,
+			Timestamp:     time.Now(),
+		})
+		if err != nil {
+			wsLogger.Error("Failed to record analysis of document %s: %v", room.ID, err)
 		}
 
-		if err := conn.WriteJSON(response); err != nil {
-			wsLogger.Error("Failed to send response to user %s: %v", userID, err)
-			break
-		}
+		// Only the requester's copy carries the request ID
+		room.Broadcast(response, c)
+		response.ID = request.ID
+		c.Send(response)
+		wsLogger.Info("Sent analysis result for document %s: %d errors, %dms", room.ID, len(errors), executionTime)
+		return
+	}
 
-		wsLogger.Info("Sent analysis result to user %s: %d errors, %dms", userID, len(errors), executionTime)
+	response.ID = request.ID
+	if err := c.Send(response); err != nil {
+		wsLogger.Error("Failed to send response to user %s: %v", principal.UserID, err)
+		return
+	}
 
-		connectionsMu.Lock()
-		if connInfo, exists := connections[conn]; exists {
-			connInfo.LastSeen = time.Now()
-		}
-		connectionsMu.Unlock()
+	wsLogger.Info("Sent analysis result to user %s: %d errors, %dms", principal.UserID, len(errors), executionTime)
+}
+
+// handleCancel abandons the analysis requested with request.RequestID, which
+// is answered with code "canceled" instead of its result
+func handleCancel(c *client, request models.AnalyzeRequest) {
+	if request.RequestID == "" {
+		sendErrorCode(c, request.ID, errorInvalidRequest, "Missing requestId field")
+		return
+	}
+	if !c.cancelAnalysis(request.RequestID) {
+		sendErrorCode(c, request.ID, errorNotFound, "No analysis in progress: "+request.RequestID)
 	}
 }
 
-func sendError(conn *websocket.Conn, message string) {
+// sendErrorCode answers the request with the ID with an error, with a code
+// clients can act on
+func sendErrorCode(c *client, id, code, message string) {
 	response := models.AnalyzeResponse{
 		Type:         "error",
+		ID:           id,
 		ErrorMessage: message,
+		ErrorCode:    code,
 	}
-	conn.WriteJSON(response)
+	c.Send(response)
 }
 
-func HandleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// sendFailed answers the request with the ID with the error an action
+// failed with, coded by its cause
+func sendFailed(c *client, id, message string, err error) {
+	sendErrorCode(c, id, errorCode(err), message+": "+err.Error())
+}
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
+// errorCode returns the code of errors of document actions
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, collab.ErrForbidden):
+		return errorForbidden
+	case errors.Is(err, collab.ErrStaleRevision):
+		return errorStaleRevision
+	case errors.Is(err, collab.ErrInvalidOp), errors.Is(err, collab.ErrInvalidComment):
+		return errorInvalidRequest
+	case errors.Is(err, collab.ErrThreadNotFound), errors.Is(err, collab.ErrUnknownRevision), errors.Is(err, store.ErrNotFound):
+		return errorNotFound
+	default:
+		return errorFailed
+	}
+}
+
+// sendRateLimited tells the client how many milliseconds to wait before
+// sending the action again
+func sendRateLimited(c *client, id, action string, retryAfter time.Duration) {
+	c.Send(models.AnalyzeResponse{
+		Type:         "error",
+		ID:           id,
+		ErrorMessage: "Rate limit exceeded for " + action + ". Please wait before sending more requests.",
+		ErrorCode:    errorRateLimited,
+		RetryAfter:   int(retryAfter.Milliseconds()),
+	})
+}
+
+// sendQuotaExceeded tells the client which quota is used up and how many
+// milliseconds until it resets
+func sendQuotaExceeded(c *client, id string, exceeded *middleware.QuotaExceededError) {
+	c.Send(models.AnalyzeResponse{
+		Type:         "error",
+		ID:           id,
+		ErrorMessage: "Quota exceeded: " + exceeded.Error(),
+		ErrorCode:    errorQuotaExceeded,
+		RetryAfter:   int(time.Until(exceeded.ResetsAt).Milliseconds()),
+	})
+}
+
+// deadline is when a timeout starting now ends, or none for a zero timeout
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
 	}
+	return time.Now().Add(timeout)
+}
 
-	w.WriteHeader(http.StatusOK)
+// EvictIdleConnections closes connections that have sent no request for
+// the idle timeout, checking a few times per timeout
+func EvictIdleConnections(idleTimeout time.Duration) {
+	if idleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(idleTimeout/4, time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleTimeout)
+
+		var idle []*client
+		connectionsMu.RLock()
+		for _, c := range connections {
+			if c.info.LastSeen.Before(cutoff) {
+				idle = append(idle, c)
+				wsLogger.Info("Closing WebSocket connection of user %s idle since %s", c.info.UserID, c.info.LastSeen.Format(time.RFC3339))
+			}
+		}
+		connectionsMu.RUnlock()
 
-	connectionsMu.RLock()
-	activeConnections := len(connections)
-	connectionsMu.RUnlock()
+		for _, c := range idle {
+			c.closeWith(closeIdle, "idle timeout")
+		}
+	}
+}
 
-	response := map[string]interface{}{
-		"status":             "healthy",
-		"timestamp":          time.Now().Format(time.RFC3339),
-		"active_connections": activeConnections,
+// writeTooManyRequests refuses a WebSocket upgrade, with how long to wait
+// if it is known
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
 	}
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+func HandleHealth(hub *collab.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		connectionsMu.RLock()
+		activeConnections := len(connections)
+		connectionsMu.RUnlock()
 
-	json.NewEncoder(w).Encode(response)
+		response := map[string]interface{}{
+			"status":             "healthy",
+			"timestamp":          time.Now().Format(time.RFC3339),
+			"active_connections": activeConnections,
+			"open_documents":     hub.Count(),
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
 }
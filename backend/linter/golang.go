@@ -0,0 +1,420 @@
+package linter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"codecollab/config"
+	"codecollab/models"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/assign"
+	"golang.org/x/tools/go/analysis/passes/atomic"
+	"golang.org/x/tools/go/analysis/passes/bools"
+	"golang.org/x/tools/go/analysis/passes/composite"
+	"golang.org/x/tools/go/analysis/passes/copylock"
+	"golang.org/x/tools/go/analysis/passes/nilfunc"
+	"golang.org/x/tools/go/analysis/passes/printf"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+	"golang.org/x/tools/go/analysis/passes/stdmethods"
+	"golang.org/x/tools/go/analysis/passes/stringintconv"
+	"golang.org/x/tools/go/analysis/passes/unmarshal"
+	"golang.org/x/tools/go/analysis/passes/unreachable"
+	"golang.org/x/tools/go/analysis/passes/unusedresult"
+)
+
+func init() {
+	RegisterBackend("builtin", newGoAnalyzer)
+}
+
+// goAnalyzers are the go/analysis passes that can be enabled by name
+var goAnalyzers = map[string]*analysis.Analyzer{
+	"assign":        assign.Analyzer,
+	"atomic":        atomic.Analyzer,
+	"bools":         bools.Analyzer,
+	"composite":     composite.Analyzer,
+	"copylock":      copylock.Analyzer,
+	"nilfunc":       nilfunc.Analyzer,
+	"printf":        printf.Analyzer,
+	"shadow":        shadow.Analyzer,
+	"stdmethods":    stdmethods.Analyzer,
+	"stringintconv": stringintconv.Analyzer,
+	"unmarshal":     unmarshal.Analyzer,
+	"unreachable":   unreachable.Analyzer,
+	"unusedresult":  unusedresult.Analyzer,
+}
+
+// GoAnalyzer parses and type-checks Go code in-process and runs the
+// configured go/analysis passes over it
+type GoAnalyzer struct {
+	analyzers []*analysis.Analyzer
+	importer  *cachingImporter
+}
+
+func newGoAnalyzer(language string, cfg *config.Config) (Linter, error) {
+	if language != "go" {
+		return nil, ErrNotConfigured
+	}
+
+	analyzers := []*analysis.Analyzer{}
+	for _, name := range cfg.GoAnalyzerPasses {
+		a, exists := goAnalyzers[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown Go analyzer: %s", name)
+		}
+		analyzers = append(analyzers, a)
+	}
+
+	imports, err := newCachingImporter()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GoAnalyzer{
+		analyzers: analyzers,
+		importer:  imports,
+	}, nil
+}
+
+// Lint reports syntax errors if the code does not parse, otherwise type
+// errors followed by analyzer diagnostics
+func (g *GoAnalyzer) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
+	src := []byte(code)
+	fset := token.NewFileSet()
+
+	file, err := parser.ParseFile(fset, "main.go", src, parser.AllErrors|parser.ParseComments)
+	if err != nil {
+		var list scanner.ErrorList
+		if !errors.As(err, &list) {
+			return nil, fmt.Errorf("failed to parse Go code: %w", err)
+		}
+
+		list.RemoveMultiples()
+
+		lintErrors := []models.LintError{}
+		for _, e := range list {
+			lintErrors = append(lintErrors, newGoLintError(src, e.Pos, token.Position{}, e.Msg, "error"))
+		}
+		return lintErrors, nil
+	}
+
+	imports := &trackingImporter{cache: g.importer, missing: make(map[string]bool)}
+	typeErrors := []types.Error{}
+	conf := types.Config{
+		Importer: imports,
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok {
+				typeErrors = append(typeErrors, typeErr)
+			}
+		},
+	}
+
+	info := &types.Info{
+		Types:        make(map[ast.Expr]types.TypeAndValue),
+		Instances:    make(map[*ast.Ident]types.Instance),
+		Defs:         make(map[*ast.Ident]types.Object),
+		Uses:         make(map[*ast.Ident]types.Object),
+		Implicits:    make(map[ast.Node]types.Object),
+		Selections:   make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:       make(map[ast.Node]*types.Scope),
+		FileVersions: make(map[*ast.File]string),
+	}
+
+	pkg, _ := conf.Check(file.Name.Name, fset, []*ast.File{file}, info)
+
+	lintErrors := []models.LintError{}
+	for _, e := range typeErrors {
+		if imports.unresolved(e.Msg) {
+			continue
+		}
+		lintErrors = append(lintErrors, newGoLintError(src, fset.Position(e.Pos), token.Position{}, e.Msg, "error"))
+	}
+	for path := range imports.missing {
+		lintErrors = append(lintErrors, models.LintError{
+			Line:     1,
+			Column:   1,
+			Message:  fmt.Sprintf("could not import %q, checks involving it were skipped", path),
+			Severity: "info",
+			Length:   1,
+		})
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pass := &analysisRun{
+		fset:       fset,
+		files:      []*ast.File{file},
+		pkg:        pkg,
+		info:       info,
+		typeErrors: typeErrors,
+		results:    make(map[*analysis.Analyzer]any),
+		facts:      make(map[factKey]analysis.Fact),
+	}
+	for _, a := range g.analyzers {
+		if len(typeErrors) > 0 && !a.RunDespiteErrors {
+			continue
+		}
+
+		diagnostics, err := pass.run(a)
+		if err != nil {
+			return nil, fmt.Errorf("analyzer %s failed: %w", a.Name, err)
+		}
+
+		for _, d := range diagnostics {
+			message := fmt.Sprintf("%s (%s)", d.Message, a.Name)
+			lintErrors = append(lintErrors, newGoLintError(src, fset.Position(d.Pos), fset.Position(d.End), message, "warning"))
+		}
+	}
+
+	sort.SliceStable(lintErrors, func(i, j int) bool {
+		if lintErrors[i].Line != lintErrors[j].Line {
+			return lintErrors[i].Line < lintErrors[j].Line
+		}
+		return lintErrors[i].Column < lintErrors[j].Column
+	})
+
+	return lintErrors, nil
+}
+
+// newGoLintError converts byte offsets into the 1-indexed line and UTF-16
+// column the editor uses; without an end the length covers the identifier
+// or token at the start position
+func newGoLintError(src []byte, start, end token.Position, message, severity string) models.LintError {
+	line := start.Line
+	if line < 1 {
+		line = 1
+	}
+
+	lineStart := start.Offset - (start.Column - 1)
+	if start.Column < 1 || lineStart < 0 || start.Offset > len(src) {
+		return models.LintError{Line: line, Column: 1, Message: message, Severity: severity, Length: 1}
+	}
+
+	endOffset := start.Offset
+	if end.IsValid() && end.Offset > start.Offset && end.Offset <= len(src) {
+		endOffset = end.Offset
+	} else {
+		for endOffset < len(src) {
+			r, size := utf8.DecodeRune(src[endOffset:])
+			if !isIdentRune(r) {
+				break
+			}
+			endOffset += size
+		}
+	}
+
+	// Keep the highlighted range on the start line
+	if newline := strings.IndexByte(string(src[start.Offset:endOffset]), '\n'); newline >= 0 {
+		endOffset = start.Offset + newline
+	}
+
+	length := utf16Len(src[start.Offset:endOffset])
+	if length < 1 {
+		length = 1
+	}
+
+	return models.LintError{
+		Line:     line,
+		Column:   utf16Len(src[lineStart:start.Offset]) + 1,
+		Message:  message,
+		Severity: severity,
+		Length:   length,
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= utf8.RuneSelf && r != utf8.RuneError
+}
+
+func utf16Len(b []byte) int {
+	return len(utf16.Encode([]rune(string(b))))
+}
+
+// cachingImporter shares imported packages between type checks; the
+// underlying importer is not safe for concurrent use
+type cachingImporter struct {
+	importer types.Importer
+	failed   map[string]error
+	mu       sync.Mutex
+}
+
+// newCachingImporter type-checks the standard library from its sources in
+// GOROOT, which unlike export data do not need the go command at runtime.
+// It fails if the sources are missing rather than reporting every import
+// as unresolved later.
+func newCachingImporter() (*cachingImporter, error) {
+	c := &cachingImporter{
+		importer: importer.ForCompiler(token.NewFileSet(), "source", nil),
+		failed:   make(map[string]error),
+	}
+	if _, err := c.Import("fmt"); err != nil {
+		return nil, fmt.Errorf("standard library sources are not available, set GOROOT: %w", err)
+	}
+	return c, nil
+}
+
+func (c *cachingImporter) Import(path string) (*types.Package, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err, exists := c.failed[path]; exists {
+		return nil, err
+	}
+
+	pkg, err := c.importer.Import(path)
+	if err != nil {
+		c.failed[path] = err
+	}
+	return pkg, err
+}
+
+// trackingImporter substitutes an empty package for imports that cannot be
+// resolved, such as third-party modules, so the rest of the file is still checked
+type trackingImporter struct {
+	cache   *cachingImporter
+	missing map[string]bool
+	names   []string
+}
+
+func (t *trackingImporter) Import(path string) (*types.Package, error) {
+	pkg, err := t.cache.Import(path)
+	if err == nil {
+		return pkg, nil
+	}
+
+	name := path[strings.LastIndex(path, "/")+1:]
+	t.missing[path] = true
+	t.names = append(t.names, name)
+
+	fake := types.NewPackage(path, name)
+	fake.MarkComplete()
+	return fake, nil
+}
+
+// unresolved reports whether a type error only concerns a missing import
+func (t *trackingImporter) unresolved(msg string) bool {
+	for _, name := range t.names {
+		if strings.HasPrefix(msg, "undefined: "+name+".") || strings.Contains(msg, "\""+name+"\" imported and not used") {
+			return true
+		}
+	}
+	for path := range t.missing {
+		if strings.Contains(msg, "\""+path+"\"") {
+			return true
+		}
+	}
+	return false
+}
+
+type factKey struct {
+	obj  types.Object
+	pkg  *types.Package
+	kind reflect.Type
+}
+
+// analysisRun is a minimal go/analysis driver for a single package,
+// running each analyzer once after its requirements
+type analysisRun struct {
+	fset       *token.FileSet
+	files      []*ast.File
+	pkg        *types.Package
+	info       *types.Info
+	typeErrors []types.Error
+	results    map[*analysis.Analyzer]any
+	facts      map[factKey]analysis.Fact
+}
+
+func (r *analysisRun) run(a *analysis.Analyzer) ([]analysis.Diagnostic, error) {
+	resultOf := make(map[*analysis.Analyzer]any)
+	for _, req := range a.Requires {
+		if _, done := r.results[req]; !done {
+			if _, err := r.run(req); err != nil {
+				return nil, err
+			}
+		}
+		resultOf[req] = r.results[req]
+	}
+
+	diagnostics := []analysis.Diagnostic{}
+	pass := &analysis.Pass{
+		Analyzer:   a,
+		Fset:       r.fset,
+		Files:      r.files,
+		Pkg:        r.pkg,
+		TypesInfo:  r.info,
+		TypesSizes: types.SizesFor("gc", runtime.GOARCH),
+		ResultOf:   resultOf,
+		Report: func(d analysis.Diagnostic) {
+			diagnostics = append(diagnostics, d)
+		},
+		ReadFile: func(filename string) ([]byte, error) {
+			return nil, fmt.Errorf("reading files is not supported: %s", filename)
+		},
+		ImportObjectFact: func(obj types.Object, fact analysis.Fact) bool {
+			return r.importFact(factKey{obj: obj, kind: reflect.TypeOf(fact)}, fact)
+		},
+		ImportPackageFact: func(pkg *types.Package, fact analysis.Fact) bool {
+			return r.importFact(factKey{pkg: pkg, kind: reflect.TypeOf(fact)}, fact)
+		},
+		ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+			r.facts[factKey{obj: obj, kind: reflect.TypeOf(fact)}] = fact
+		},
+		ExportPackageFact: func(fact analysis.Fact) {
+			r.facts[factKey{pkg: r.pkg, kind: reflect.TypeOf(fact)}] = fact
+		},
+		AllObjectFacts: func() []analysis.ObjectFact {
+			facts := []analysis.ObjectFact{}
+			for key, fact := range r.facts {
+				if key.obj != nil {
+					facts = append(facts, analysis.ObjectFact{Object: key.obj, Fact: fact})
+				}
+			}
+			return facts
+		},
+		AllPackageFacts: func() []analysis.PackageFact {
+			facts := []analysis.PackageFact{}
+			for key, fact := range r.facts {
+				if key.pkg != nil {
+					facts = append(facts, analysis.PackageFact{Package: key.pkg, Fact: fact})
+				}
+			}
+			return facts
+		},
+	}
+	if a.RunDespiteErrors {
+		pass.TypeErrors = r.typeErrors
+	}
+
+	result, err := a.Run(pass)
+	if err != nil {
+		return nil, err
+	}
+	r.results[a] = result
+
+	return diagnostics, nil
+}
+
+func (r *analysisRun) importFact(key factKey, fact analysis.Fact) bool {
+	stored, exists := r.facts[key]
+	if !exists {
+		return false
+	}
+	reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(stored).Elem())
+	return true
+}
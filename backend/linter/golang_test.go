@@ -0,0 +1,100 @@
+package linter
+
+import (
+	"context"
+	"testing"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+func TestGoAnalyzer(t *testing.T) {
+	l, err := newGoAnalyzer("go", &config.Config{GoAnalyzerPasses: []string{"printf", "unusedresult"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want []models.LintError
+	}{
+		{
+			name: "clean",
+			code: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n",
+			want: []models.LintError{},
+		},
+		{
+			name: "printf",
+			code: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Printf(\"%d\\n\", \"x\")\n}\n",
+			want: []models.LintError{{
+				Line:     6,
+				Column:   14,
+				Message:  "fmt.Printf format %d has arg \"x\" of wrong type string (printf)",
+				Severity: "warning",
+				Length:   2,
+			}},
+		},
+		{
+			name: "unusedresult",
+			code: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Sprintf(\"%d\", 1)\n}\n",
+			want: []models.LintError{{
+				Line:     6,
+				Column:   2,
+				Message:  "result of fmt.Sprintf call not used (unusedresult)",
+				Severity: "warning",
+				Length:   11,
+			}},
+		},
+		{
+			name: "type error skips analyzers",
+			code: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Sprintf(\"%d\", x)\n}\n",
+			want: []models.LintError{{
+				Line:     6,
+				Column:   20,
+				Message:  "undefined: x",
+				Severity: "error",
+				Length:   1,
+			}},
+		},
+		{
+			name: "syntax error",
+			code: "package main\n\nfunc main() {\n\tx :=\n}\n",
+			want: []models.LintError{{
+				Line:     5,
+				Column:   1,
+				Message:  "expected operand, found '}'",
+				Severity: "error",
+				Length:   1,
+			}},
+		},
+		{
+			name: "unresolved import",
+			code: "package main\n\nimport \"example.com/lib\"\n\nfunc main() {\n\tlib.Run()\n}\n",
+			want: []models.LintError{{
+				Line:     1,
+				Column:   1,
+				Message:  "could not import \"example.com/lib\", checks involving it were skipped",
+				Severity: "info",
+				Length:   1,
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Lint(context.Background(), "go", tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Lint = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("finding %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
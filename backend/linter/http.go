@@ -0,0 +1,126 @@
+package linter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+func init() {
+	RegisterBackend("http", newHTTPLinter)
+}
+
+// maxHTTPResponseSize caps how much of a linter response is read
+const maxHTTPResponseSize = 10 * 1024 * 1024
+
+// HTTPLinter POSTs a LambdaRequest to a URL and decodes the same
+// {statusCode, body} envelope the Lambda functions return
+type HTTPLinter struct {
+	client     *http.Client
+	url        string
+	authHeader string
+	authToken  string
+}
+
+func newHTTPLinter(language string, cfg *config.Config) (Linter, error) {
+	url := cfg.HTTPLinterURLs[language]
+	if url == "" {
+		return nil, ErrNotConfigured
+	}
+
+	tlsConfig, err := createTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &HTTPLinter{
+		client: &http.Client{
+			Timeout:   cfg.HTTPLinterTimeout,
+			Transport: transport,
+		},
+		url:        url,
+		authHeader: cfg.HTTPLinterAuthHeader,
+		authToken:  cfg.HTTPLinterAuthToken,
+	}, nil
+}
+
+// Lint sends the code to the linter service and parses its response
+func (l *HTTPLinter) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
+	payload, err := json.Marshal(models.LambdaRequest{
+		Language: language,
+		Code:     code,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if l.authToken != "" {
+		req.Header.Set(l.authHeader, l.authToken)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call linter service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read linter response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("linter service returned status %d: %s", resp.StatusCode, truncate(string(body), 500))
+	}
+
+	return decodeEnvelope(body)
+}
+
+// createTLSConfig builds the TLS settings shared by all HTTP linters
+func createTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.HTTPLinterInsecureSkipVerify,
+	}
+
+	if cfg.HTTPLinterCAFile != "" {
+		caCert, err := os.ReadFile(cfg.HTTPLinterCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", cfg.HTTPLinterCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.HTTPLinterClientCert != "" || cfg.HTTPLinterClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.HTTPLinterClientCert, cfg.HTTPLinterClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -1,4 +1,4 @@
-package handlers
+package linter
 
 import (
 	"context"
@@ -14,25 +14,37 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 )
 
-// InvokeLinter invokes the appropriate Lambda function based on the language
-func InvokeLinter(language, code string, cfg *config.Config) ([]models.LintError, error) {
-	// Get the appropriate Lambda ARN for the language
-	lambdaARN, err := getLambdaARN(language, cfg)
-	if err != nil {
-		return nil, err
-	}
+func init() {
+	RegisterBackend("lambda", newLambdaLinter)
+}
 
-	// If using mock Lambda (for testing), return mock data
-	if cfg.UseMockLambda {
-		return getMockLintErrors(language), nil
+// LambdaLinter invokes an AWS Lambda function that lints a single language
+type LambdaLinter struct {
+	client *lambda.Client
+	arn    string
+}
+
+func newLambdaLinter(language string, cfg *config.Config) (Linter, error) {
+	// Get the appropriate Lambda ARN for the language
+	arn := getLambdaARN(language, cfg)
+	if arn == "" {
+		return nil, ErrNotConfigured
 	}
 
 	// Create AWS Lambda client
-	lambdaClient, err := createLambdaClient(cfg)
+	client, err := createLambdaClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Lambda client: %w", err)
 	}
 
+	return &LambdaLinter{
+		client: client,
+		arn:    arn,
+	}, nil
+}
+
+// Lint invokes the Lambda function with the code and parses its response
+func (l *LambdaLinter) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
 	// Prepare the request payload
 	request := models.LambdaRequest{
 		Language: language,
@@ -45,8 +57,8 @@ func InvokeLinter(language, code string, cfg *config.Config) ([]models.LintError
 	}
 
 	// Invoke the Lambda function
-	result, err := lambdaClient.Invoke(context.TODO(), &lambda.InvokeInput{
-		FunctionName: aws.String(lambdaARN),
+	result, err := l.client.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(l.arn),
 		Payload:      payload,
 	})
 
@@ -59,13 +71,17 @@ func InvokeLinter(language, code string, cfg *config.Config) ([]models.LintError
 		return nil, fmt.Errorf("Lambda function error: %s", *result.FunctionError)
 	}
 
-	// Parse the response - Lambda returns {statusCode, body} format
+	return decodeEnvelope(result.Payload)
+}
+
+// decodeEnvelope parses the {statusCode, body} format returned by the linter handlers
+func decodeEnvelope(payload []byte) ([]models.LintError, error) {
 	var lambdaAPIResponse struct {
 		StatusCode int    `json:"statusCode"`
 		Body       string `json:"body"`
 	}
 
-	if err := json.Unmarshal(result.Payload, &lambdaAPIResponse); err != nil {
+	if err := json.Unmarshal(payload, &lambdaAPIResponse); err != nil {
 		return nil, fmt.Errorf("failed to parse Lambda API response: %w", err)
 	}
 
@@ -83,30 +99,22 @@ func InvokeLinter(language, code string, cfg *config.Config) ([]models.LintError
 	return response.Errors, nil
 }
 
-// getLambdaARN returns the Lambda ARN for the specified language
-func getLambdaARN(language string, cfg *config.Config) (string, error) {
-	var arn string
-
+// getLambdaARN returns the Lambda ARN configured for the canonical language
+func getLambdaARN(language string, cfg *config.Config) string {
 	switch language {
-	case "typescript", "javascript":
-		arn = cfg.LambdaARNTypeScript
+	case "typescript":
+		return cfg.LambdaARNTypeScript
 	case "python":
-		arn = cfg.LambdaARNPython
+		return cfg.LambdaARNPython
 	case "dart":
-		arn = cfg.LambdaARNDart
-	case "go", "golang":
-		arn = cfg.LambdaARNGo
-	case "cpp", "c++":
-		arn = cfg.LambdaARNCpp
+		return cfg.LambdaARNDart
+	case "go":
+		return cfg.LambdaARNGo
+	case "cpp":
+		return cfg.LambdaARNCpp
 	default:
-		return "", fmt.Errorf("unsupported language: %s", language)
-	}
-
-	if arn == "" {
-		return "", fmt.Errorf("Lambda ARN not configured for language: %s", language)
+		return ""
 	}
-
-	return arn, nil
 }
 
 // createLambdaClient creates an AWS Lambda client with the provided configuration
@@ -126,16 +134,3 @@ func createLambdaClient(cfg *config.Config) (*lambda.Client, error) {
 
 	return lambda.NewFromConfig(awsCfg), nil
 }
-
-// getMockLintErrors returns mock lint errors for testing purposes
-func getMockLintErrors(language string) []models.LintError {
-	return []models.LintError{
-		{
-			Line:     1,
-			Column:   1,
-			Message:  fmt.Sprintf("Mock error for %s (testing mode)", language),
-			Severity: "warning",
-			Length:   10,
-		},
-	}
-}
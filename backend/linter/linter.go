@@ -0,0 +1,152 @@
+package linter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"codecollab/config"
+	"codecollab/models"
+	"codecollab/utils"
+)
+
+// Linter analyzes source code and returns the lint errors it found
+type Linter interface {
+	Lint(ctx context.Context, language, code string) ([]models.LintError, error)
+}
+
+// Factory builds a Linter for a canonical language from the configuration
+type Factory func(language string, cfg *config.Config) (Linter, error)
+
+// ErrNotConfigured is returned by a Factory when the backend has no settings
+// for the requested language, in which case the language is left unregistered
+var ErrNotConfigured = errors.New("linter not configured")
+
+var (
+	backends   = make(map[string]Factory)
+	backendsMu sync.RWMutex
+	logger     = utils.NewLogger("linter")
+)
+
+// RegisterBackend makes a linter backend selectable by name from configuration
+func RegisterBackend(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, exists := backends[name]; exists {
+		panic("linter: backend registered twice: " + name)
+	}
+	backends[name] = factory
+}
+
+// CanonicalLanguage maps language aliases onto the name linters are registered under
+func CanonicalLanguage(language string) string {
+	switch strings.ToLower(language) {
+	case "typescript", "javascript":
+		return "typescript"
+	case "go", "golang":
+		return "go"
+	case "cpp", "c++":
+		return "cpp"
+	default:
+		return strings.ToLower(language)
+	}
+}
+
+// Registry routes lint requests to the Linter registered for each language
+type Registry struct {
+	linters map[string]Linter
+	mu      sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		linters: make(map[string]Linter),
+	}
+}
+
+// NewRegistryFromConfig builds a registry using the backend configured for
+// every supported language
+func NewRegistryFromConfig(cfg *config.Config) (*Registry, error) {
+	registry := NewRegistry()
+
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	for _, language := range config.SupportedLanguages {
+		name := cfg.LinterBackendFor(language)
+
+		factory, exists := backends[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown linter backend %q for language: %s", name, language)
+		}
+
+		l, err := factory(language, cfg)
+		if errors.Is(err, ErrNotConfigured) {
+			logger.Warn("No %s linter configured for language: %s", name, language)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s linter for %s: %w", name, language, err)
+		}
+
+		registry.Register(language, l)
+		logger.Info("Registered %s linter for language: %s", name, language)
+	}
+
+	return registry, nil
+}
+
+// Register sets the linter used for a language and its aliases
+func (r *Registry) Register(language string, l Linter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.linters[CanonicalLanguage(language)] = l
+}
+
+// Get returns the linter registered for a language
+func (r *Registry) Get(language string) (Linter, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	canonical := CanonicalLanguage(language)
+	if l, exists := r.linters[canonical]; exists {
+		return l, nil
+	}
+
+	for _, supported := range config.SupportedLanguages {
+		if supported == canonical {
+			return nil, fmt.Errorf("no linter configured for language: %s", language)
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported language: %s", language)
+}
+
+// Languages lists the canonical languages that have a linter registered
+func (r *Registry) Languages() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	languages := make([]string, 0, len(r.linters))
+	for language := range r.linters {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
+
+// Lint runs the linter registered for the language against the code
+func (r *Registry) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
+	l, err := r.Get(language)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Lint(ctx, language, code)
+}
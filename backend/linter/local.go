@@ -0,0 +1,186 @@
+package linter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+func init() {
+	RegisterBackend("local", newLocalLinter)
+}
+
+// fileExtensions is the extension given to the temp file for each canonical language
+var fileExtensions = map[string]string{
+	"typescript": ".ts",
+	"python":     ".py",
+	"dart":       ".dart",
+	"go":         ".go",
+	"cpp":        ".cpp",
+}
+
+// LocalLinter runs a linter command on a temp file containing the code
+type LocalLinter struct {
+	command    []string
+	extension  string
+	timeout    time.Duration
+	memoryMB   int
+	cpuSeconds int
+}
+
+func newLocalLinter(language string, cfg *config.Config) (Linter, error) {
+	command := strings.Fields(cfg.LocalLinterCommands[language])
+	if len(command) == 0 {
+		return nil, ErrNotConfigured
+	}
+
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, fmt.Errorf("linter command not found: %w", err)
+	}
+
+	return &LocalLinter{
+		command:    command,
+		extension:  fileExtensions[language],
+		timeout:    cfg.LocalLinterTimeout,
+		memoryMB:   cfg.LocalLinterMemoryMB,
+		cpuSeconds: cfg.LocalLinterCPUSeconds,
+	}, nil
+}
+
+// Lint writes the code to a temp directory, runs the command on it and parses its output
+func (l *LocalLinter) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
+	dir, err := os.MkdirTemp("", "codecollab-lint-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "main"+l.extension)
+	if err := os.WriteFile(file, []byte(code), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	cmd := l.buildCommand(ctx, file)
+	cmd.Dir = dir
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("linter command aborted: %w", ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return nil, fmt.Errorf("failed to run linter command: %w", runErr)
+	}
+
+	lintErrors := parseLinterOutput(output.String(), filepath.Base(file))
+
+	// Linters exit non-zero when they report problems, so only treat the
+	// exit status as a failure when nothing could be parsed from the output
+	if runErr != nil && len(lintErrors) == 0 {
+		return nil, fmt.Errorf("linter command failed: %v: %s", runErr, truncate(output.String(), 500))
+	}
+
+	return lintErrors, nil
+}
+
+// buildCommand substitutes the file path into the command, appending it when
+// the command has no "{file}" placeholder
+func (l *LocalLinter) buildCommand(ctx context.Context, file string) *exec.Cmd {
+	args := make([]string, 0, len(l.command)+1)
+	substituted := false
+	for _, arg := range l.command {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", file)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, file)
+	}
+
+	return limitedCommand(ctx, args, l.memoryMB, l.cpuSeconds)
+}
+
+var (
+	// file:line:col: [severity:] message (gcc, clang-tidy, go vet, ruff, pylint)
+	gnuPattern = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*(?:(error|warning|note|info)\w*:\s*)?(.*)$`)
+	// file(line,col): severity message (tsc)
+	tscPattern = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\):\s*(?:(error|warning|message)\s*)?(.*)$`)
+)
+
+// parseLinterOutput extracts diagnostics that refer to the linted file
+func parseLinterOutput(output, fileName string) []models.LintError {
+	lintErrors := []models.LintError{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		match := tscPattern.FindStringSubmatch(line)
+		if match == nil {
+			match = gnuPattern.FindStringSubmatch(line)
+		}
+		if match == nil || filepath.Base(match[1]) != fileName {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		if column == 0 {
+			column = 1
+		}
+
+		lintErrors = append(lintErrors, models.LintError{
+			Line:     lineNum,
+			Column:   column,
+			Message:  strings.TrimSpace(match[5]),
+			Severity: normalizeSeverity(match[4]),
+			Length:   1,
+		})
+	}
+
+	return lintErrors
+}
+
+func normalizeSeverity(severity string) string {
+	switch severity {
+	case "error":
+		return "error"
+	case "note", "info", "message":
+		return "info"
+	default:
+		return "warning"
+	}
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > n {
+		return s[:n] + "... (truncated)"
+	}
+	return s
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package linter
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// limitedCommand runs the command directly; resource limits are only
+// enforced on unix, the timeout still applies through the context
+func limitedCommand(ctx context.Context, args []string, memoryMB, cpuSeconds int) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
@@ -0,0 +1,63 @@
+package linter
+
+import (
+	"reflect"
+	"testing"
+
+	"codecollab/models"
+)
+
+func TestParseLinterOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		fileName string
+		want     []models.LintError
+	}{
+		{
+			name:     "gnu style with severity",
+			output:   "/tmp/lint123/main.dart:3:7: error: Undefined name 'x'.\n",
+			fileName: "main.dart",
+			want:     []models.LintError{{Line: 3, Column: 7, Message: "Undefined name 'x'.", Severity: "error", Length: 1}},
+		},
+		{
+			name:     "gnu style without severity",
+			output:   "main.py:12:80: E501 line too long (88 > 79 characters)",
+			fileName: "main.py",
+			want:     []models.LintError{{Line: 12, Column: 80, Message: "E501 line too long (88 > 79 characters)", Severity: "warning", Length: 1}},
+		},
+		{
+			name:     "gnu style without column",
+			output:   "main.go:4: note: declared here",
+			fileName: "main.go",
+			want:     []models.LintError{{Line: 4, Column: 1, Message: "declared here", Severity: "info", Length: 1}},
+		},
+		{
+			name:     "tsc style",
+			output:   "main.ts(5,10): error TS2322: Type 'string' is not assignable to type 'number'.",
+			fileName: "main.ts",
+			want:     []models.LintError{{Line: 5, Column: 10, Message: "TS2322: Type 'string' is not assignable to type 'number'.", Severity: "error", Length: 1}},
+		},
+		{
+			name:     "other files and noise",
+			output:   "Analyzing main.dart...\n/usr/lib/dart/core.dart:1:1: error: broken\n\nmain.dart:2:3: warning: unused import\n1 issue found.",
+			fileName: "main.dart",
+			want:     []models.LintError{{Line: 2, Column: 3, Message: "unused import", Severity: "warning", Length: 1}},
+		},
+		{
+			name:     "no findings",
+			output:   "",
+			fileName: "main.py",
+			want:     []models.LintError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLinterOutput(tt.output, tt.fileName)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLinterOutput(%q) = %+v, want %+v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
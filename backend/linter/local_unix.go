@@ -0,0 +1,34 @@
+//go:build unix
+
+package linter
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// limitedCommand runs the command through sh so that ulimit can cap its CPU
+// time and address space, in its own process group so a timeout kills any
+// children the linter spawned as well
+func limitedCommand(ctx context.Context, args []string, memoryMB, cpuSeconds int) *exec.Cmd {
+	script := ""
+	if cpuSeconds > 0 {
+		script += fmt.Sprintf("ulimit -t %d; ", cpuSeconds)
+	}
+	if memoryMB > 0 {
+		script += fmt.Sprintf("ulimit -v %d; ", memoryMB*1024)
+	}
+	script += `exec "$0" "$@"`
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
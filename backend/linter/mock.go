@@ -0,0 +1,30 @@
+package linter
+
+import (
+	"context"
+	"fmt"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+func init() {
+	RegisterBackend("mock", func(language string, cfg *config.Config) (Linter, error) {
+		return MockLinter{}, nil
+	})
+}
+
+// MockLinter returns a fixed lint error for testing purposes
+type MockLinter struct{}
+
+func (MockLinter) Lint(ctx context.Context, language, code string) ([]models.LintError, error) {
+	return []models.LintError{
+		{
+			Line:     1,
+			Column:   1,
+			Message:  fmt.Sprintf("Mock error for %s (testing mode)", language),
+			Severity: "warning",
+			Length:   10,
+		},
+	}, nil
+}
@@ -10,10 +10,14 @@ import (
 	"syscall"
 	"time"
 
+	"codecollab/auth"
+	"codecollab/collab"
 	"codecollab/config"
 	"codecollab/handlers"
+	"codecollab/linter"
 	"codecollab/metrics"
 	"codecollab/middleware"
+	"codecollab/store"
 	"codecollab/utils"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -38,14 +42,73 @@ func main() {
 	})
 	logger.Info("Loki logger initialized: %s", cfg.LokiURL)
 
+	linters, err := linter.NewRegistryFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure linters: %v", err)
+	}
+	logger.Info("Linters registered for: %v", linters.Languages())
+
+	documents, err := store.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open document store: %v", err)
+	}
+	logger.Info("Document store: %s", cfg.DocumentStore)
+
+	authenticator, err := auth.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	logger.Info("Auth providers: %v", cfg.AuthProviders)
+	revocations := auth.NewRevocations(cfg.RevocationTTL)
+	authenticator = revocations.Wrap(authenticator)
+	tickets := auth.NewTicketStore(cfg.WSTicketTTL)
+
+	rateLimitStore, err := middleware.NewRateLimitStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open rate limit store: %v", err)
+	}
+	logger.Info("Rate limit store: %s", cfg.RateLimitStore)
+	limiter, err := middleware.NewRateLimiterFromConfig(cfg, rateLimitStore)
+	if err != nil {
+		log.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	guard, err := middleware.NewConnectionGuardFromConfig(cfg, rateLimitStore)
+	if err != nil {
+		log.Fatalf("Failed to configure connection limits: %v", err)
+	}
+	usage, err := store.NewUsageStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open usage store: %v", err)
+	}
+	logger.Info("Usage store: %s", cfg.UsageStore)
+	quotas, err := middleware.NewQuotasFromConfig(cfg, usage)
+	if err != nil {
+		log.Fatalf("Failed to configure quotas: %v", err)
+	}
+
+	hub := collab.NewHub(documents, cfg.DocumentSnapshotInterval)
+	go hub.FlushPeriodically(cfg.DocumentFlushInterval)
+	go hub.ExpirePresence(cfg.PresenceIdleTimeout)
+	go handlers.EvictIdleConnections(cfg.WSIdleTimeout)
+
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/ws", handlers.HandleWebSocket(cfg))
-	mux.HandleFunc("/health", handlers.HandleHealth)
+	mux.HandleFunc("/ws", handlers.HandleWebSocket(cfg, authenticator, tickets, revocations, guard, limiter, quotas, linters, hub))
+	mux.HandleFunc("/health", handlers.HandleHealth(hub))
 	mux.Handle("/metrics", promhttp.Handler())
 
+	mux.HandleFunc("/api/v1/ws-ticket", middleware.CORSHandlerFunc(handlers.HandleWebSocketTicket(authenticator, tickets)))
+	mux.HandleFunc("/api/v1/usage", middleware.CORSHandlerFunc(handlers.HandleUsage(authenticator, quotas)))
+	mux.HandleFunc("/api/v1/admin/revocations", middleware.CORSHandlerFunc(handlers.HandleRevocations(authenticator, revocations, cfg.AdminRole)))
+	mux.HandleFunc("/api/v1/admin/revocations/{id}", middleware.CORSHandlerFunc(handlers.HandleRevocation(authenticator, revocations, cfg.AdminRole)))
+	mux.HandleFunc("/api/v1/documents/{id}/revisions", middleware.CORSHandlerFunc(handlers.HandleDocumentRevisions(authenticator, hub)))
+	mux.HandleFunc("/api/v1/documents/{id}/diff", middleware.CORSHandlerFunc(handlers.HandleDocumentDiff(authenticator, hub)))
+	mux.HandleFunc("/api/v1/documents/{id}/restore", middleware.CORSHandlerFunc(handlers.HandleDocumentRestore(authenticator, hub)))
+	mux.HandleFunc("/api/v1/documents/{id}/replay", middleware.CORSHandlerFunc(handlers.HandleDocumentReplay(authenticator, hub)))
+
 	mux.HandleFunc("/swagger.yaml", handlers.ServeSwaggerYAML)
 	mux.HandleFunc("/docs", handlers.ServeSwaggerUI)
+	mux.HandleFunc("/schemas/{name}", handlers.ServeSchema)
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -99,5 +162,13 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	hub.FlushAll()
+	if err := documents.Close(); err != nil {
+		logger.Error("Failed to close document store: %v", err)
+	}
+	if err := usage.Close(); err != nil {
+		logger.Error("Failed to close usage store: %v", err)
+	}
+
 	logger.Info("Server stopped")
 }
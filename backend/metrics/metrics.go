@@ -54,6 +54,31 @@ var (
 			Help: "Number of goroutines currently running",
 		},
 	)
+
+	// WebSocketSendQueueDepth has a series per open connection, deleted
+	// when the connection closes
+	WebSocketSendQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "websocket_send_queue_depth",
+			Help: "Messages waiting to be written to each open WebSocket connection",
+		},
+		[]string{"session"},
+	)
+
+	WebSocketMessagesDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_messages_dropped_total",
+			Help: "Outgoing WebSocket messages dropped or replaced by newer ones before being written",
+		},
+		[]string{"reason"},
+	)
+
+	WebSocketSlowConsumers = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_slow_consumer_disconnects_total",
+			Help: "WebSocket connections closed for not reading their messages fast enough",
+		},
+	)
 )
 
 func Init() {
@@ -63,4 +88,7 @@ func Init() {
 	prometheus.MustRegister(HttpResponseSize)
 	prometheus.MustRegister(HttpRequestsInFlight)
 	prometheus.MustRegister(GoRoutinesCount)
+	prometheus.MustRegister(WebSocketSendQueueDepth)
+	prometheus.MustRegister(WebSocketMessagesDropped)
+	prometheus.MustRegister(WebSocketSlowConsumers)
 }
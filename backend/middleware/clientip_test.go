@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed header from an untrusted peer", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:443", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"trusted proxy without header", "10.0.0.2:443", nil, "10.0.0.2"},
+		{"chain of trusted proxies", "10.0.0.2:443", []string{"203.0.113.7, 192.168.1.1, 10.1.2.3"}, "203.0.113.7"},
+		{"chain across headers", "10.0.0.2:443", []string{"203.0.113.7", "192.168.1.1"}, "203.0.113.7"},
+		// The client prepends a made-up address, which the first untrusted
+		// hop hides
+		{"spoofed start of the chain", "10.0.0.2:443", []string{"1.2.3.4, 203.0.113.7, 10.1.2.3"}, "203.0.113.7"},
+		{"chain ending in trusted proxies", "10.0.0.2:443", []string{"10.9.9.9, 192.168.1.1"}, "10.9.9.9"},
+		{"malformed hop", "10.0.0.2:443", []string{"1.2.3.4, garbage, 10.1.2.3"}, "10.1.2.3"},
+		{"IPv6 proxy", "[fd00::1]:443", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"untrusted single address neighbour", "192.168.1.2:443", []string{"1.2.3.4"}, "192.168.1.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{"proxy.internal", "10.0.0.0/33", "300.1.1.1"} {
+		if _, err := ParseTrustedProxies([]string{spec}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", spec)
+		}
+	}
+}
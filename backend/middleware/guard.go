@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"codecollab/config"
+)
+
+// ErrTooManyConnections is returned when a user or IP already has as many
+// sockets open as allowed
+var ErrTooManyConnections = errors.New("too many connections")
+
+// ConnectionGuard protects the WebSocket upgrade before and around
+// authentication: it limits connection attempts per IP, bans IPs that keep
+// failing to authenticate and caps the sockets open per user and per IP.
+// Attempts are counted in the rate limit store; bans and open sockets are
+// counted by each instance.
+type ConnectionGuard struct {
+	proxies TrustedProxies
+
+	store    RateLimitStore
+	attempts Limit
+	failOpen bool
+
+	maxPerUser int
+	maxPerIP   int
+
+	failureLimit  int
+	failureWindow time.Duration
+	banDuration   time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+	byUser   map[string]int
+	byIP     map[string]int
+}
+
+// authFailures counts an IP's failed authentications since the window began
+type authFailures struct {
+	count       int
+	since       time.Time
+	bannedUntil time.Time
+}
+
+// NewConnectionGuardFromConfig builds a guard counting connection attempts
+// in the store
+func NewConnectionGuardFromConfig(cfg *config.Config, store RateLimitStore) (*ConnectionGuard, error) {
+	proxies, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := ParseLimit(cfg.WSConnectRateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("connection attempts: %w", err)
+	}
+
+	g := &ConnectionGuard{
+		proxies:       proxies,
+		store:         store,
+		attempts:      attempts,
+		failOpen:      cfg.RateLimitFailOpen,
+		maxPerUser:    cfg.WSMaxConnsPerUser,
+		maxPerIP:      cfg.WSMaxConnsPerIP,
+		failureLimit:  cfg.WSAuthFailureLimit,
+		failureWindow: cfg.WSAuthFailureWindow,
+		banDuration:   cfg.WSAuthBanDuration,
+		failures:      make(map[string]*authFailures),
+		byUser:        make(map[string]int),
+		byIP:          make(map[string]int),
+	}
+
+	go g.cleanup()
+
+	return g, nil
+}
+
+// ClientIP returns the address the request is counted under
+func (g *ConnectionGuard) ClientIP(r *http.Request) string {
+	return g.proxies.ClientIP(r)
+}
+
+// Admit takes a connection attempt from the IP's budget, returning how long
+// until it may try again if it is banned or over its budget
+func (g *ConnectionGuard) Admit(ctx context.Context, ip string) (bool, time.Duration) {
+	now := time.Now()
+
+	g.mu.Lock()
+	f, exists := g.failures[ip]
+	if exists && now.Before(f.bannedUntil) {
+		g.mu.Unlock()
+		return false, f.bannedUntil.Sub(now)
+	}
+	g.mu.Unlock()
+
+	if g.attempts.unlimited() {
+		return true, 0
+	}
+	allowed, retryAfter, err := g.store.Take(ctx, "connect:"+ip, g.attempts, now)
+	if err != nil {
+		rateLimitLogger.Error("Rate limit store failed for IP %s: %v", ip, err)
+		if g.failOpen {
+			return true, 0
+		}
+		return false, time.Second
+	}
+	return allowed, retryAfter
+}
+
+// AuthFailed counts a failed authentication from the IP, banning it once
+// the failures within the window reach the limit
+func (g *ConnectionGuard) AuthFailed(ip string) {
+	if g.failureLimit <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	f, exists := g.failures[ip]
+	if !exists {
+		f = &authFailures{since: now}
+		g.failures[ip] = f
+	}
+	if now.Sub(f.since) > g.failureWindow {
+		f.count, f.since = 0, now
+	}
+
+	f.count++
+	if f.count >= g.failureLimit {
+		f.bannedUntil = now.Add(g.banDuration)
+		f.count, f.since = 0, now
+		rateLimitLogger.Warn("Banned IP %s for %v after %d failed authentications", ip, g.banDuration, g.failureLimit)
+	}
+}
+
+// Acquire counts a socket opened by the user from the IP, or returns
+// ErrTooManyConnections if either has as many open as allowed. Each acquired
+// socket must be released when it closes.
+func (g *ConnectionGuard) Acquire(userID, ip string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.maxPerUser > 0 && g.byUser[userID] >= g.maxPerUser {
+		return fmt.Errorf("%w for user %s", ErrTooManyConnections, userID)
+	}
+	if g.maxPerIP > 0 && g.byIP[ip] >= g.maxPerIP {
+		return fmt.Errorf("%w from %s", ErrTooManyConnections, ip)
+	}
+	g.byUser[userID]++
+	g.byIP[ip]++
+	return nil
+}
+
+// Release counts a socket acquired by the user from the IP as closed
+func (g *ConnectionGuard) Release(userID, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.byUser[userID]--; g.byUser[userID] <= 0 {
+		delete(g.byUser, userID)
+	}
+	if g.byIP[ip]--; g.byIP[ip] <= 0 {
+		delete(g.byIP, ip)
+	}
+}
+
+// cleanup forgets failures whose window and ban have both passed
+func (g *ConnectionGuard) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.mu.Lock()
+		now := time.Now()
+
+		for ip, f := range g.failures {
+			if now.Sub(f.since) > g.failureWindow && now.After(f.bannedUntil) {
+				delete(g.failures, ip)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
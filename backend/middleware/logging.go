@@ -1,18 +1,28 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"codecollab/utils"
 )
 
+// maxLoggedBody is how much of a request or response body is logged
+const maxLoggedBody = 10000
+
+// responseCapture keeps the start of the response body for the request log.
+// Event streams are not kept, since they can run for hours.
 type responseCapture struct {
 	http.ResponseWriter
 	statusCode int
 	body       *bytes.Buffer
+	truncated  bool
 	written    int64
 }
 
@@ -22,12 +32,77 @@ func (rc *responseCapture) WriteHeader(code int) {
 }
 
 func (rc *responseCapture) Write(b []byte) (int, error) {
-	rc.body.Write(b)
+	if !rc.streaming() {
+		keep := min(len(b), maxLoggedBody-rc.body.Len())
+		rc.body.Write(b[:keep])
+		rc.truncated = rc.truncated || keep < len(b)
+	}
 	n, err := rc.ResponseWriter.Write(b)
 	rc.written += int64(n)
 	return n, err
 }
 
+// streaming reports whether the response is an event stream
+func (rc *responseCapture) streaming() bool {
+	return strings.HasPrefix(rc.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// streamed responses
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
+// Flush sends buffered data to the client for handlers that flush through
+// http.Flusher
+func (rc *responseCapture) Flush() {
+	http.NewResponseController(rc.ResponseWriter).Flush()
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (rc *responseCapture) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rc.ResponseWriter).Hijack()
+	if err == nil {
+		rc.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// redactedHeaders and redactedParams carry credentials, which are kept out
+// of request logs
+var (
+	redactedHeaders = map[string]bool{
+		"Authorization":          true,
+		"Cookie":                 true,
+		"Sec-Websocket-Protocol": true,
+	}
+	redactedParams = []string{"token", "ticket", "shareToken"}
+)
+
+const redacted = "[REDACTED]"
+
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	changed := false
+	for _, name := range redactedParams {
+		if _, exists := query[name]; exists {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return query.Encode()
+}
+
 func LoggingMiddleware(logger *utils.LokiLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -53,24 +128,32 @@ func LoggingMiddleware(logger *utils.LokiLogger) func(http.Handler) http.Handler
 			for key, values := range r.Header {
 				if len(values) > 0 {
 					headers[key] = values[0]
+					if redactedHeaders[key] {
+						headers[key] = redacted
+					}
 				}
 			}
 
 			responseBody := rc.body.String()
-			if len(responseBody) > 10000 {
-				responseBody = responseBody[:10000] + "... (truncated)"
+			if rc.truncated {
+				responseBody += "... (truncated)"
+			}
+			// Responses that must not be stored, such as issued credentials,
+			// are not logged either
+			if strings.Contains(rc.Header().Get("Cache-Control"), "no-store") {
+				responseBody = redacted
 			}
 
 			requestBodyStr := string(requestBody)
-			if len(requestBodyStr) > 10000 {
-				requestBodyStr = requestBodyStr[:10000] + "... (truncated)"
+			if len(requestBodyStr) > maxLoggedBody {
+				requestBodyStr = requestBodyStr[:maxLoggedBody] + "... (truncated)"
 			}
 
 			logger.LogRequest(utils.RequestLog{
 				Timestamp:      start,
 				Method:         r.Method,
 				Path:           r.URL.Path,
-				Query:          r.URL.RawQuery,
+				Query:          redactQuery(r.URL.RawQuery),
 				Headers:        headers,
 				RequestBody:    requestBodyStr,
 				ResponseBody:   responseBody,
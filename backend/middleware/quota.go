@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/config"
+	"codecollab/models"
+	"codecollab/store"
+	"codecollab/utils"
+)
+
+var quotaLogger = utils.NewLogger("quota")
+
+// byteUnits are the suffixes a byte quota may be written with
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseQuota reads a quota written as "analyses=500,bytes=50MB,time=10m", or
+// "unlimited". Entries left out, or zero, are unlimited.
+func ParseQuota(spec string) (models.Usage, error) {
+	var quota models.Usage
+	spec = strings.TrimSpace(spec)
+	if spec == "unlimited" || spec == "" {
+		return quota, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return quota, fmt.Errorf("invalid quota %q, expected name=value entries", spec)
+		}
+
+		var err error
+		switch name {
+		case "analyses":
+			quota.Analyses, err = strconv.ParseInt(value, 10, 64)
+		case "bytes":
+			quota.Bytes, err = parseBytes(value)
+		case "time":
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			quota.ExecutionTime = d.Milliseconds()
+		default:
+			return quota, fmt.Errorf("unknown entry %q in quota %q", name, spec)
+		}
+		if err != nil {
+			return quota, fmt.Errorf("invalid %s in quota %q", name, spec)
+		}
+	}
+
+	if quota.Analyses < 0 || quota.Bytes < 0 || quota.ExecutionTime < 0 {
+		return quota, fmt.Errorf("negative entry in quota %q", spec)
+	}
+	return quota, nil
+}
+
+func parseBytes(value string) (int64, error) {
+	for _, unit := range byteUnits {
+		if number, found := strings.CutSuffix(value, unit.suffix); found {
+			n, err := strconv.ParseInt(number, 10, 64)
+			return n * unit.size, err
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// QuotaExceededError is returned for analyses over a user's or tenant's quota
+type QuotaExceededError struct {
+	// Scope is "user" or "tenant"
+	Scope    string
+	Period   string
+	Metric   string
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s %s quota of %s exceeded", e.Period, e.Scope, e.Metric)
+}
+
+// Quotas counts the linter use of users and their tenants over each day and
+// month, and refuses analyses once a quota is used up. Checks and records
+// are separate, so concurrent analyses may overshoot a quota slightly.
+type Quotas struct {
+	usage store.UsageStore
+	// limits by plan, or config.QuotaTenant, then period
+	limits      map[string]map[string]models.Usage
+	plans       []string
+	defaultPlan string
+}
+
+// NewQuotas limits users by the plans given, most generous first, counting
+// their use in the store
+func NewQuotas(usage store.UsageStore, limits map[string]map[string]models.Usage, plans []string, defaultPlan string) *Quotas {
+	return &Quotas{
+		usage:       usage,
+		limits:      limits,
+		plans:       plans,
+		defaultPlan: defaultPlan,
+	}
+}
+
+// NewQuotasFromConfig builds quotas with the configured limit for every plan
+// and period
+func NewQuotasFromConfig(cfg *config.Config, usage store.UsageStore) (*Quotas, error) {
+	limits := make(map[string]map[string]models.Usage)
+	for _, plan := range slices.Concat(config.RateLimitPlans, []string{config.QuotaTenant}) {
+		limits[plan] = make(map[string]models.Usage)
+		for _, period := range config.QuotaPeriods {
+			quota, err := ParseQuota(cfg.Quotas[plan+"."+period])
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", plan, period, err)
+			}
+			limits[plan][period] = quota
+		}
+	}
+
+	return NewQuotas(usage, limits, config.RateLimitPlans, cfg.RateLimitDefaultPlan), nil
+}
+
+// quotaScope is the user or tenant use is counted for
+type quotaScope struct {
+	name   string
+	id     string
+	limits map[string]models.Usage
+}
+
+func (q *Quotas) scopes(p *auth.Principal) []quotaScope {
+	scopes := []quotaScope{{"user", p.UserID, q.limits[planOf(p, q.plans, q.defaultPlan)]}}
+	if p.Tenant != "" {
+		scopes = append(scopes, quotaScope{"tenant", p.Tenant, q.limits[config.QuotaTenant]})
+	}
+	return scopes
+}
+
+// Check returns a *QuotaExceededError if analyzing bytes of code would go
+// over a quota of the principal or their tenant. Analyses are allowed when
+// the store fails, since use is only counted after the fact anyway.
+func (q *Quotas) Check(ctx context.Context, p *auth.Principal, bytes int) error {
+	now := time.Now()
+	for _, scope := range q.scopes(p) {
+		for _, period := range config.QuotaPeriods {
+			limit := scope.limits[period]
+			if limit == (models.Usage{}) {
+				continue
+			}
+
+			name, resetsAt := currentPeriod(period, now)
+			used, err := q.usage.GetUsage(ctx, usageKey(scope, name))
+			if err != nil {
+				quotaLogger.Error("Failed to read %s usage of %s %s: %v", period, scope.name, scope.id, err)
+				continue
+			}
+
+			metric := ""
+			switch {
+			case limit.Analyses > 0 && used.Analyses >= limit.Analyses:
+				metric = "analyses"
+			case limit.Bytes > 0 && used.Bytes+int64(bytes) > limit.Bytes:
+				metric = "bytes"
+			case limit.ExecutionTime > 0 && used.ExecutionTime >= limit.ExecutionTime:
+				metric = "executionTime"
+			}
+			if metric != "" {
+				return &QuotaExceededError{Scope: scope.name, Period: period, Metric: metric, ResetsAt: resetsAt}
+			}
+		}
+	}
+	return nil
+}
+
+// Record counts an analysis of bytes of code that ran the linter for the
+// execution time against the principal and their tenant
+func (q *Quotas) Record(ctx context.Context, p *auth.Principal, bytes int, executionTime time.Duration) error {
+	now := time.Now()
+	var keys []string
+	for _, scope := range q.scopes(p) {
+		for _, period := range config.QuotaPeriods {
+			name, _ := currentPeriod(period, now)
+			keys = append(keys, usageKey(scope, name))
+		}
+	}
+
+	return q.usage.AddUsage(ctx, keys, models.Usage{
+		Analyses:      1,
+		Bytes:         int64(bytes),
+		ExecutionTime: executionTime.Milliseconds(),
+	})
+}
+
+// Report returns the principal's use in the current periods and what is
+// left of their quotas
+func (q *Quotas) Report(ctx context.Context, p *auth.Principal) (*models.UsageReport, error) {
+	report := &models.UsageReport{Plan: planOf(p, q.plans, q.defaultPlan)}
+
+	now := time.Now()
+	for _, scope := range q.scopes(p) {
+		periods := make(map[string]models.QuotaPeriod)
+		for _, period := range config.QuotaPeriods {
+			name, resetsAt := currentPeriod(period, now)
+			used, err := q.usage.GetUsage(ctx, usageKey(scope, name))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read usage: %w", err)
+			}
+
+			limit := scope.limits[period]
+			periods[period] = models.QuotaPeriod{
+				Period:        name,
+				ResetsAt:      resetsAt,
+				Analyses:      quotaMetric(used.Analyses, limit.Analyses),
+				Bytes:         quotaMetric(used.Bytes, limit.Bytes),
+				ExecutionTime: quotaMetric(used.ExecutionTime, limit.ExecutionTime),
+			}
+		}
+
+		if scope.name == "tenant" {
+			report.Tenant, report.TenantUsage = scope.id, periods
+		} else {
+			report.Usage = periods
+		}
+	}
+	return report, nil
+}
+
+func quotaMetric(used, limit int64) models.QuotaMetric {
+	metric := models.QuotaMetric{Used: used}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		metric.Limit, metric.Remaining = &limit, &remaining
+	}
+	return metric
+}
+
+// currentPeriod names the UTC day or month containing now and returns when
+// the next one starts
+func currentPeriod(period string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+	if period == "monthly" {
+		return now.Format("2006-01"), time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return now.Format("2006-01-02"), time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// usageKey is where a scope's use in a period is stored; days and months
+// are told apart by the format of their names
+func usageKey(scope quotaScope, period string) string {
+	return scope.name + ":" + scope.id + ":" + period
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/models"
+	"codecollab/store"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		spec  string
+		want  models.Usage
+		valid bool
+	}{
+		{"unlimited", models.Usage{}, true},
+		{"", models.Usage{}, true},
+		{"analyses=500", models.Usage{Analyses: 500}, true},
+		{"analyses=500, bytes=50MB, time=10m", models.Usage{Analyses: 500, Bytes: 50 << 20, ExecutionTime: 600000}, true},
+		{"bytes=2KB", models.Usage{Bytes: 2048}, true},
+		{"bytes=100", models.Usage{Bytes: 100}, true},
+		{"analyses", models.Usage{}, false},
+		{"analyses=many", models.Usage{}, false},
+		{"bytes=1TB", models.Usage{}, false},
+		{"time=10", models.Usage{}, false},
+		{"analyses=-1", models.Usage{}, false},
+		{"requests=5", models.Usage{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseQuota(tt.spec)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseQuota(%q) error = %v, want valid %v", tt.spec, err, tt.valid)
+			continue
+		}
+		if tt.valid && got != tt.want {
+			t.Errorf("ParseQuota(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCurrentPeriod(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	tests := []struct {
+		period   string
+		now      time.Time
+		name     string
+		resetsAt time.Time
+	}{
+		{"daily", time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), "2026-10-17", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"daily", time.Date(2026, 10, 31, 23, 59, 59, 0, time.UTC), "2026-10-31", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"daily", time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), "2026-12-31", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"daily", time.Date(2026, 10, 17, 20, 0, 0, 0, est), "2026-10-18", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"monthly", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), "2026-10", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"monthly", time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC), "2026-12", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"monthly", time.Date(2026, 10, 31, 22, 0, 0, 0, est), "2026-11", time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		name, resetsAt := currentPeriod(tt.period, tt.now)
+		if name != tt.name || !resetsAt.Equal(tt.resetsAt) {
+			t.Errorf("currentPeriod(%s, %s) = %s, %s, want %s, %s", tt.period, tt.now, name, resetsAt, tt.name, tt.resetsAt)
+		}
+	}
+}
+
+func TestQuotasCheck(t *testing.T) {
+	limits := map[string]map[string]models.Usage{
+		"pro": {
+			"daily":   {Analyses: 10},
+			"monthly": {Analyses: 100, Bytes: 1000},
+		},
+		"free": {
+			"daily":   {Analyses: 2},
+			"monthly": {ExecutionTime: 500},
+		},
+		"tenant": {
+			"monthly": {Analyses: 3},
+		},
+	}
+
+	free := &auth.Principal{UserID: "user-1"}
+	pro := &auth.Principal{UserID: "user-1", Roles: []string{"pro"}}
+	tenant := &auth.Principal{UserID: "user-1", Tenant: "acme", Roles: []string{"pro"}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		recorded  []models.Usage
+		bytes     int
+		scope     string
+		period    string
+		metric    string
+	}{
+		{"under the limits", free, []models.Usage{{Analyses: 1}}, 100, "", "", ""},
+		{"daily analyses used up", free, []models.Usage{{Analyses: 1}, {Analyses: 1}}, 100, "user", "daily", "analyses"},
+		{"monthly time used up", free, []models.Usage{{Analyses: 1, ExecutionTime: 500}}, 100, "user", "monthly", "executionTime"},
+		{"plan limits", pro, []models.Usage{{Analyses: 1}, {Analyses: 1}}, 100, "", "", ""},
+		{"bytes would go over", pro, []models.Usage{{Analyses: 1, Bytes: 900}}, 101, "user", "monthly", "bytes"},
+		{"bytes up to the limit", pro, []models.Usage{{Analyses: 1, Bytes: 900}}, 100, "", "", ""},
+		{"tenant used up", tenant, []models.Usage{{Analyses: 1}, {Analyses: 1}, {Analyses: 1}}, 100, "tenant", "monthly", "analyses"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			quotas := NewQuotas(store.NewMemoryUsageStore(), limits, []string{"pro", "free"}, "free")
+			for _, usage := range tt.recorded {
+				err := quotas.Record(ctx, tt.principal, int(usage.Bytes), time.Duration(usage.ExecutionTime)*time.Millisecond)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := quotas.Check(ctx, tt.principal, tt.bytes)
+			if tt.metric == "" {
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+				return
+			}
+
+			var exceeded *QuotaExceededError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("Check = %v, want a QuotaExceededError", err)
+			}
+			if exceeded.Scope != tt.scope || exceeded.Period != tt.period || exceeded.Metric != tt.metric {
+				t.Errorf("Check = %s %s %s, want %s %s %s", exceeded.Scope, exceeded.Period, exceeded.Metric, tt.scope, tt.period, tt.metric)
+			}
+			if _, resetsAt := currentPeriod(tt.period, time.Now()); !exceeded.ResetsAt.Equal(resetsAt) {
+				t.Errorf("ResetsAt = %s, want %s", exceeded.ResetsAt, resetsAt)
+			}
+		})
+	}
+}
@@ -1,93 +1,190 @@
 package middleware
 
 import (
-	"sync"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
+
+	"codecollab/auth"
+	"codecollab/config"
+	"codecollab/utils"
 )
 
+var rateLimitLogger = utils.NewLogger("ratelimit")
 
-type RateLimiter struct {
-	requests map[string][]time.Time
-	mu       sync.RWMutex
-	limit    int
-	window   time.Duration
+// Limit is a token bucket: a user may make Burst requests at once, and the
+// bucket refills at Rate requests a second
+type Limit struct {
+	Rate  float64
+	Burst int
 }
 
+// Unlimited is a limit that never refuses a request
+var Unlimited = Limit{Rate: math.Inf(1)}
 
+func (l Limit) unlimited() bool {
+	return math.IsInf(l.Rate, 1)
+}
 
-
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
+// ParseLimit reads a limit written as "requests/period:burst", e.g.
+// "60/1m:10" or "5/s", or "unlimited". Without a burst, the burst is one period's
+// requests.
+func ParseLimit(spec string) (Limit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "unlimited" {
+		return Unlimited, nil
 	}
 
-	
-	go rl.cleanup()
+	rateSpec, burstSpec, hasBurst := strings.Cut(spec, ":")
+	countSpec, periodSpec, found := strings.Cut(rateSpec, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected requests/period:burst", spec)
+	}
 
-	return rl
-}
+	count, err := strconv.Atoi(countSpec)
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", spec)
+	}
+	// "60/m" reads as "60/1m"
+	if periodSpec != "" && (periodSpec[0] < '0' || periodSpec[0] > '9') {
+		periodSpec = "1" + periodSpec
+	}
+	period, err := time.ParseDuration(periodSpec)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", spec)
+	}
 
+	burst := count
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstSpec); err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid burst in rate limit %q", spec)
+		}
+	}
 
-func (rl *RateLimiter) CheckRateLimit(userID string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	return Limit{Rate: float64(count) / period.Seconds(), Burst: burst}, nil
+}
 
-	now := time.Now()
-	windowStart := now.Add(-rl.window)
+// RateLimiter keeps a token bucket per user and budget in its store. The
+// limits depend on the user's plan; actions without a budget of their own
+// share the "default" budget.
+type RateLimiter struct {
+	// limits by plan, then budget
+	limits      map[string]map[string]Limit
+	plans       []string
+	defaultPlan string
+
+	store RateLimitStore
+	// timeout of each call to the store
+	timeout time.Duration
+	// whether requests are allowed when the store cannot be reached
+	failOpen bool
+}
 
-	
-	requests, exists := rl.requests[userID]
-	if !exists {
-		rl.requests[userID] = []time.Time{now}
-		return true
+// NewRateLimiter limits users by the plans given, most generous first, with
+// buckets kept in the store. Each plan must have a "default" limit.
+func NewRateLimiter(store RateLimitStore, limits map[string]map[string]Limit, plans []string, defaultPlan string) (*RateLimiter, error) {
+	for _, plan := range plans {
+		if _, exists := limits[plan][config.DefaultRateLimitAction]; !exists {
+			return nil, fmt.Errorf("rate limit plan %q has no %s limit", plan, config.DefaultRateLimitAction)
+		}
+	}
+	if _, exists := limits[defaultPlan]; !exists {
+		return nil, fmt.Errorf("unknown default rate limit plan %q", defaultPlan)
 	}
 
-	
-	validRequests := []time.Time{}
-	for _, reqTime := range requests {
-		if reqTime.After(windowStart) {
-			validRequests = append(validRequests, reqTime)
+	return &RateLimiter{
+		limits:      limits,
+		plans:       plans,
+		defaultPlan: defaultPlan,
+		store:       store,
+		failOpen:    true,
+	}, nil
+}
+
+// NewRateLimiterFromConfig builds a limiter with the configured limit for
+// every plan and budget, keeping buckets in the store
+func NewRateLimiterFromConfig(cfg *config.Config, store RateLimitStore) (*RateLimiter, error) {
+	limits := make(map[string]map[string]Limit)
+	for _, plan := range config.RateLimitPlans {
+		limits[plan] = make(map[string]Limit)
+		for _, action := range config.RateLimitActions {
+			spec, exists := cfg.RateLimits[plan+"."+action]
+			if !exists {
+				continue
+			}
+			limit, err := ParseLimit(spec)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", plan, action, err)
+			}
+			limits[plan][action] = limit
 		}
 	}
 
-	
-	if len(validRequests) >= rl.limit {
-		return false
+	rl, err := NewRateLimiter(store, limits, config.RateLimitPlans, cfg.RateLimitDefaultPlan)
+	if err != nil {
+		return nil, err
 	}
+	rl.timeout = cfg.RateLimitTimeout
+	rl.failOpen = cfg.RateLimitFailOpen
+	return rl, nil
+}
 
-	
-	validRequests = append(validRequests, now)
-	rl.requests[userID] = validRequests
-
-	return true
+// SetFailOpen sets whether requests are allowed or refused while the store
+// is failing. Limiters fail open unless told otherwise.
+func (rl *RateLimiter) SetFailOpen(failOpen bool) {
+	rl.failOpen = failOpen
 }
 
+// Plan returns the most generous plan the principal has a role for, or the
+// default plan
+func (rl *RateLimiter) Plan(p *auth.Principal) string {
+	return planOf(p, rl.plans, rl.defaultPlan)
+}
 
-func (rl *RateLimiter) cleanup() {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+func planOf(p *auth.Principal, plans []string, defaultPlan string) string {
+	for _, plan := range plans {
+		if p.HasRole(plan) {
+			return plan
+		}
+	}
+	return defaultPlan
+}
 
-	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		windowStart := now.Add(-rl.window)
+// Allow takes one request of the action from the principal's budget for it,
+// returning how long until the next request would be allowed if it cannot.
+// When the store fails, the request is allowed if the limiter fails open and
+// refused for a second otherwise.
+func (rl *RateLimiter) Allow(ctx context.Context, p *auth.Principal, action string) (bool, time.Duration) {
+	budget, limit := rl.limit(rl.Plan(p), action)
+	if limit.unlimited() {
+		return true, 0
+	}
 
-		for userID, requests := range rl.requests {
-			validRequests := []time.Time{}
-			for _, reqTime := range requests {
-				if reqTime.After(windowStart) {
-					validRequests = append(validRequests, reqTime)
-				}
-			}
+	if rl.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.timeout)
+		defer cancel()
+	}
 
-			if len(validRequests) == 0 {
-				delete(rl.requests, userID)
-			} else {
-				rl.requests[userID] = validRequests
-			}
+	// budgets never contain a colon, so the key is unambiguous
+	allowed, retryAfter, err := rl.store.Take(ctx, budget+":"+p.UserID, limit, time.Now())
+	if err != nil {
+		rateLimitLogger.Error("Rate limit store failed for user %s: %v", p.UserID, err)
+		if rl.failOpen {
+			return true, 0
 		}
-		rl.mu.Unlock()
+		return false, time.Second
+	}
+	return allowed, retryAfter
+}
+
+// limit returns the budget an action is counted against on the plan and its limit
+func (rl *RateLimiter) limit(plan, action string) (string, Limit) {
+	if limit, exists := rl.limits[plan][action]; exists {
+		return action, limit
 	}
+	return config.DefaultRateLimitAction, rl.limits[plan][config.DefaultRateLimitAction]
 }
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript is the token bucket of RateLimitStore.Take, run atomically in
+// Redis. The bucket is a hash of its tokens and when it was last updated, in
+// milliseconds, and expires once it would have refilled completely.
+//
+// KEYS[1] bucket key
+// ARGV[1] rate in tokens per millisecond
+// ARGV[2] burst
+// ARGV[3] now in milliseconds
+//
+// Returns {allowed (0 or 1), milliseconds until a token is available}
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+
+-- instances' clocks may disagree, so time never runs backwards for a bucket
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "updated", updated)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+
+return {allowed, wait}
+`)
+
+// RedisRateLimitStore keeps buckets in Redis, so every instance sharing it
+// limits users together
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRateLimitStore keeps buckets under keys starting with the prefix
+func NewRedisRateLimitStore(client redis.UniversalClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client, prefix: prefix}
+}
+
+// NewRedisRateLimitStoreFromURL connects to the Redis server at a
+// redis:// or rediss:// URL
+func NewRedisRateLimitStoreFromURL(url, prefix string) (*RedisRateLimitStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		// the limiter fails open or closed until Redis is reachable
+		rateLimitLogger.Warn("Redis at %s is not reachable: %v", opts.Addr, err)
+	}
+
+	return NewRedisRateLimitStore(client, prefix), nil
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	result, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		limit.Rate/1000, limit.Burst, now.UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// Close disconnects from Redis
+func (s *RedisRateLimitStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testLimit = Limit{Rate: 2, Burst: 3}
+
+func newTestRedisStore(t *testing.T) (*RedisRateLimitStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func newTestRateLimiter(t *testing.T, store RateLimitStore) *RateLimiter {
+	t.Helper()
+	limits := map[string]map[string]Limit{
+		"free": {config.DefaultRateLimitAction: testLimit},
+	}
+	rl, err := NewRateLimiter(store, limits, []string{"free"}, "free")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rl
+}
+
+func TestRedisStoreMatchesMemoryStore(t *testing.T) {
+	redisStore, _ := newTestRedisStore(t)
+	memoryStore := NewMemoryRateLimitStore()
+	ctx := context.Background()
+
+	rng := rand.New(rand.NewSource(1))
+	now := time.UnixMilli(1_700_000_000_000)
+
+	// Bursts of requests with pauses between them, so buckets run dry,
+	// refill partly and refill completely
+	for i := 0; i < 500; i++ {
+		if rng.Intn(3) == 0 {
+			now = now.Add(time.Duration(rng.Intn(2000)) * time.Millisecond)
+		}
+		key := []string{"a", "b"}[rng.Intn(2)]
+
+		wantAllowed, wantRetry, err := memoryStore.Take(ctx, key, testLimit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed, retry, err := redisStore.Take(ctx, key, testLimit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if allowed != wantAllowed {
+			t.Fatalf("request %d for %s: allowed = %v, memory store says %v", i, key, allowed, wantAllowed)
+		}
+		// Redis counts in whole milliseconds
+		if diff := retry - wantRetry; diff <= -time.Millisecond || diff >= time.Millisecond {
+			t.Fatalf("request %d for %s: retry after %v, memory store says %v", i, key, retry, wantRetry)
+		}
+	}
+}
+
+func TestRedisStoreBurstAndRefill(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	ctx := context.Background()
+	now := time.UnixMilli(1_700_000_000_000)
+
+	for i := 0; i < testLimit.Burst; i++ {
+		if allowed, _, err := store.Take(ctx, "user", testLimit, now); err != nil || !allowed {
+			t.Fatalf("request %d of the burst: allowed = %v, err = %v", i, allowed, err)
+		}
+	}
+
+	allowed, retry, err := store.Take(ctx, "user", testLimit, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed || retry != 500*time.Millisecond {
+		t.Fatalf("request after the burst: allowed = %v, retry after %v, want refused for 500ms", allowed, retry)
+	}
+
+	if allowed, _, _ := store.Take(ctx, "user", testLimit, now.Add(499*time.Millisecond)); allowed {
+		t.Fatal("request before a token refilled was allowed")
+	}
+	if allowed, _, _ := store.Take(ctx, "user", testLimit, now.Add(500*time.Millisecond)); !allowed {
+		t.Fatal("request after a token refilled was refused")
+	}
+
+	// An earlier clock on another instance does not refill the bucket backwards
+	if allowed, _, _ := store.Take(ctx, "user", testLimit, now); allowed {
+		t.Fatal("request with an earlier clock was allowed")
+	}
+}
+
+func TestRedisStorePrefixesAndExpiresKeys(t *testing.T) {
+	store, server := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, _, err := store.Take(ctx, "default:user", testLimit, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "test:default:user" {
+		t.Fatalf("keys = %v, want [test:default:user]", keys)
+	}
+
+	// One token taken refills in 500ms, after which the bucket is as good as new
+	if ttl := server.TTL("test:default:user"); ttl != 501*time.Millisecond {
+		t.Fatalf("TTL = %v, want 501ms", ttl)
+	}
+	server.FastForward(501 * time.Millisecond)
+	if server.Exists("test:default:user") {
+		t.Fatal("bucket did not expire once refilled")
+	}
+}
+
+func TestRateLimiterWhenRedisFails(t *testing.T) {
+	principal := &auth.Principal{UserID: "user"}
+	ctx := context.Background()
+
+	t.Run("fail open", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+		server.SetError("ERR unavailable")
+		rl := newTestRateLimiter(t, store)
+
+		for i := 0; i < 2*testLimit.Burst; i++ {
+			if allowed, retry := rl.Allow(ctx, principal, "edit"); !allowed || retry != 0 {
+				t.Fatalf("request %d: allowed = %v, retry after %v, want allowed", i, allowed, retry)
+			}
+		}
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+		server.SetError("ERR unavailable")
+		rl := newTestRateLimiter(t, store)
+		rl.SetFailOpen(false)
+
+		if allowed, retry := rl.Allow(ctx, principal, "edit"); allowed || retry != time.Second {
+			t.Fatalf("allowed = %v, retry after %v, want refused for 1s", allowed, retry)
+		}
+	})
+
+	t.Run("memory fallback", func(t *testing.T) {
+		store, server := newTestRedisStore(t)
+		rl := newTestRateLimiter(t, NewFallbackRateLimitStore(store, NewMemoryRateLimitStore()))
+		rl.SetFailOpen(false)
+
+		server.SetError("ERR unavailable")
+		for i := 0; i < testLimit.Burst; i++ {
+			if allowed, _ := rl.Allow(ctx, principal, "edit"); !allowed {
+				t.Fatalf("request %d of the burst was refused", i)
+			}
+		}
+		if allowed, retry := rl.Allow(ctx, principal, "edit"); allowed || retry <= 0 || retry > 500*time.Millisecond {
+			t.Fatalf("request after the burst: allowed = %v, retry after %v, want refused until a token refills", allowed, retry)
+		}
+
+		// Once Redis recovers its buckets are used again, and this one is full
+		server.SetError("")
+		if allowed, _ := rl.Allow(ctx, principal, "edit"); !allowed {
+			t.Fatal("request after Redis recovered was refused")
+		}
+		if !server.Exists("test:default:user") {
+			t.Fatal("request after Redis recovered did not use its bucket")
+		}
+	})
+}
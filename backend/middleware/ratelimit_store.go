@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"codecollab/config"
+)
+
+// RateLimitStore keeps the token buckets of a RateLimiter
+type RateLimitStore interface {
+	// Take refills the bucket at key under the limit as of now and takes a
+	// token from it, or returns how long until one is available
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// NewRateLimitStore opens the rate limit store selected in the configuration
+func NewRateLimitStore(cfg *config.Config) (RateLimitStore, error) {
+	switch cfg.RateLimitStore {
+	case "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "redis":
+		store, err := NewRedisRateLimitStoreFromURL(cfg.RedisURL, cfg.RateLimitRedisPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.RateLimitMemoryFallback {
+			return NewFallbackRateLimitStore(store, NewMemoryRateLimitStore()), nil
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %s", cfg.RateLimitStore)
+	}
+}
+
+// FallbackRateLimitStore takes tokens from a fallback store while its primary
+// store fails, so users are still limited by each instance rather than not
+// at all or not served at all
+type FallbackRateLimitStore struct {
+	primary  RateLimitStore
+	fallback RateLimitStore
+}
+
+func NewFallbackRateLimitStore(primary, fallback RateLimitStore) *FallbackRateLimitStore {
+	return &FallbackRateLimitStore{primary: primary, fallback: fallback}
+}
+
+func (s *FallbackRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	allowed, retryAfter, err := s.primary.Take(ctx, key, limit, now)
+	if err == nil {
+		return allowed, retryAfter, nil
+	}
+	rateLimitLogger.Warn("Using fallback rate limit store for %s: %v", key, err)
+	// The primary's deadline may be what failed, so it does not bound the fallback
+	return s.fallback.Take(context.WithoutCancel(ctx), key, limit, now)
+}
+
+// MemoryRateLimitStore keeps buckets in memory, so each instance limits
+// users separately
+type MemoryRateLimitStore struct {
+	buckets map[string]*bucket
+	mu      sync.Mutex
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+	}
+
+	go s.cleanup()
+
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	allowed, retryAfter := b.take(limit, now)
+	return allowed, retryAfter, nil
+}
+
+// cleanup forgets buckets that have refilled, since a new bucket starts full
+func (s *MemoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+
+		for key, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// limit the bucket was last used under
+	limit Limit
+}
+
+// take refills the bucket for the time since it was last used and takes a
+// token from it, or returns how long until one is available
+func (b *bucket) take(limit Limit, now time.Time) (bool, time.Duration) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	b.updated = now
+	b.limit = limit
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// full reports whether the bucket has refilled completely, so forgetting it
+// changes nothing
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
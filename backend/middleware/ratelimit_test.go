@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"codecollab/auth"
+	"codecollab/config"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Limit
+		wantErr bool
+	}{
+		{spec: "60/1m:10", want: Limit{Rate: 1, Burst: 10}},
+		{spec: "60/m", want: Limit{Rate: 1, Burst: 60}},
+		{spec: "5/s", want: Limit{Rate: 5, Burst: 5}},
+		{spec: "10/2s:1", want: Limit{Rate: 5, Burst: 1}},
+		{spec: "1/1h", want: Limit{Rate: 1.0 / 3600, Burst: 1}},
+		{spec: " 3/500ms ", want: Limit{Rate: 6, Burst: 3}},
+		{spec: "unlimited", want: Unlimited},
+		{spec: "", wantErr: true},
+		{spec: "60", wantErr: true},
+		{spec: "0/m", wantErr: true},
+		{spec: "-1/m", wantErr: true},
+		{spec: "x/m", wantErr: true},
+		{spec: "60/", wantErr: true},
+		{spec: "60/0s", wantErr: true},
+		{spec: "60/fortnight", wantErr: true},
+		{spec: "60/m:0", wantErr: true},
+		{spec: "60/m:many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseLimit(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLimit(%q) = %+v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLimit(%q): %v", tt.spec, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 3}
+	start := time.UnixMilli(1_700_000_000_000)
+
+	// Each step takes a token at the time given since the start
+	tests := []struct {
+		name      string
+		at        time.Duration
+		allowed   bool
+		retryWait time.Duration
+	}{
+		{"burst 1", 0, true, 0},
+		{"burst 2", 0, true, 0},
+		{"burst 3", 0, true, 0},
+		{"empty", 0, false, 500 * time.Millisecond},
+		{"partly refilled", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"refilled one", 500 * time.Millisecond, true, 0},
+		{"empty again", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		// A long pause refills no more than the burst
+		{"after a pause 1", time.Hour, true, 0},
+		{"after a pause 2", time.Hour, true, 0},
+		{"after a pause 3", time.Hour, true, 0},
+		{"after a pause 4", time.Hour, false, 500 * time.Millisecond},
+	}
+
+	b := &bucket{tokens: float64(limit.Burst), updated: start}
+	for _, tt := range tests {
+		allowed, retryAfter := b.take(limit, start.Add(tt.at))
+		if allowed != tt.allowed || retryAfter != tt.retryWait {
+			t.Fatalf("%s: take = %v, retry after %v, want %v, retry after %v", tt.name, allowed, retryAfter, tt.allowed, tt.retryWait)
+		}
+	}
+
+	if b.full(start.Add(time.Hour)) {
+		t.Error("empty bucket reported full")
+	}
+	if !b.full(start.Add(time.Hour + 1500*time.Millisecond)) {
+		t.Error("refilled bucket not reported full")
+	}
+}
+
+func TestRateLimiterBudgets(t *testing.T) {
+	rl := &RateLimiter{
+		limits: map[string]map[string]Limit{
+			"free": {
+				config.DefaultRateLimitAction: {Rate: 1, Burst: 1},
+				"analyze":                     {Rate: 2, Burst: 2},
+			},
+			"pro": {
+				config.DefaultRateLimitAction: Unlimited,
+			},
+		},
+		plans:       []string{"pro", "free"},
+		defaultPlan: "free",
+	}
+
+	if plan := rl.Plan(&auth.Principal{UserID: "a"}); plan != "free" {
+		t.Errorf("plan without roles = %s, want free", plan)
+	}
+	if plan := rl.Plan(&auth.Principal{UserID: "a", Roles: []string{"free", "pro"}}); plan != "pro" {
+		t.Errorf("plan with free and pro roles = %s, want pro", plan)
+	}
+
+	tests := []struct {
+		plan, action string
+		budget       string
+		limit        Limit
+	}{
+		{"free", "analyze", "analyze", Limit{Rate: 2, Burst: 2}},
+		{"free", "edit", config.DefaultRateLimitAction, Limit{Rate: 1, Burst: 1}},
+		{"pro", "analyze", config.DefaultRateLimitAction, Unlimited},
+	}
+	for _, tt := range tests {
+		budget, limit := rl.limit(tt.plan, tt.action)
+		if budget != tt.budget || limit != tt.limit {
+			t.Errorf("limit(%s, %s) = %s %+v, want %s %+v", tt.plan, tt.action, budget, limit, tt.budget, tt.limit)
+		}
+	}
+}
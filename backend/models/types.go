@@ -1,12 +1,60 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Envelope wraps every WebSocket message in version 1 of the protocol. Type
+// is the action of a request or what a server message is, and the payload
+// holds the rest of the message's fields.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
 
 
 type AnalyzeRequest struct {
-	Action   string `json:"action"`
-	Language string `json:"language"`
-	Code     string `json:"code"`
+	// ID is chosen by the client and echoed in the response
+	ID         string   `json:"id,omitempty"`
+	Action     string   `json:"action"`
+	Language   string   `json:"language"`
+	Code       *string  `json:"code"`
+	DocumentID string   `json:"documentId,omitempty"`
+	Revision   int      `json:"revision,omitempty"`
+	Ops        []TextOp `json:"ops,omitempty"`
+
+	// Revision range for history, diff and restore
+	From  int `json:"from,omitempty"`
+	To    int `json:"to,omitempty"`
+	Limit int `json:"limit,omitempty"`
+
+	// Comment threads
+	ThreadID string `json:"threadId,omitempty"`
+	Range    *Range `json:"range,omitempty"`
+	Body     string `json:"body,omitempty"`
+	Resolved *bool  `json:"resolved,omitempty"`
+
+	// Sharing: the link token granting access on join, or the user and
+	// role to share with and link lifetime in seconds
+	ShareToken string `json:"shareToken,omitempty"`
+	UserID     string `json:"userId,omitempty"`
+	Role       Role   `json:"role,omitempty"`
+	ExpiresIn  int    `json:"expiresIn,omitempty"`
+
+	DisplayName string     `json:"displayName,omitempty"`
+	Color       string     `json:"color,omitempty"`
+	Selection   *Selection `json:"selection,omitempty"`
+
+	// Fresh access token replacing the connection's expiring one
+	Token string `json:"token,omitempty"`
+
+	// Abandon analyses of the same document still in progress, or with
+	// cancel, the ID of the analysis to abandon
+	Supersede bool   `json:"supersede,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
 
@@ -21,15 +69,222 @@ type LintError struct {
 
 type AnalyzeResponse struct {
 	Type          string      `json:"type"` 
+	// ID of the request answered, if it had one
+	ID            string      `json:"id,omitempty"`
+	DocumentID    string      `json:"documentId,omitempty"`
 	Errors        []LintError `json:"errors,omitempty"`
 	ErrorMessage  string      `json:"message,omitempty"`
+	ErrorCode     string      `json:"code,omitempty"`
 	ExecutionTime int         `json:"executionTime,omitempty"` 
+	// Milliseconds to wait before retrying a rate limited request
+	RetryAfter    int         `json:"retryAfter,omitempty"`
+}
+
+
+// TextOp is one component of an edit: retain or delete a number of
+// characters (Unicode code points), or insert text at the current position
+type TextOp struct {
+	Retain int    `json:"retain,omitempty"`
+	Insert string `json:"insert,omitempty"`
+	Delete int    `json:"delete,omitempty"`
+}
+
+
+// AuthMessage tells a connection when its credentials expire, as a warning
+// ahead of time or after reauthenticating
+type AuthMessage struct {
+	Type      string    `json:"type"`
+	// ID of the request answered, if it had one
+	ID        string    `json:"id,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DocumentMessage is sent to the members of a collaborative document room
+type DocumentMessage struct {
+	Type         string          `json:"type"`
+	// ID of the request answered, if it had one
+	ID           string          `json:"id,omitempty"`
+	DocumentID   string          `json:"documentId"`
+	UserID       string          `json:"userId,omitempty"`
+	SessionID    string          `json:"sessionId,omitempty"`
+	Language     string          `json:"language,omitempty"`
+	Content      string          `json:"content,omitempty"`
+	Revision     int             `json:"revision,omitempty"`
+	Ops          []TextOp        `json:"ops,omitempty"`
+	Participants []string        `json:"participants,omitempty"`
+	Participant  *Participant    `json:"participant,omitempty"`
+	Presence     []Participant   `json:"presence,omitempty"`
+	Threads      []CommentThread `json:"threads,omitempty"`
+	Thread       *CommentThread  `json:"thread,omitempty"`
+	Role         Role            `json:"role,omitempty"`
+}
+
+
+// Role is a user's access to a document; each role can do everything the
+// roles after it can
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleEditor    Role = "editor"
+	RoleCommenter Role = "commenter"
+	RoleViewer    Role = "viewer"
+)
+
+
+// ShareLink grants the role to anyone joining the document with its token
+// until it expires
+type ShareLink struct {
+	Token     string    `json:"token"`
+	Role      Role      `json:"role"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+
+// SharingMessage describes who has access to a document
+type SharingMessage struct {
+	Type          string          `json:"type"`
+	// ID of the request answered, if it had one
+	ID            string          `json:"id,omitempty"`
+	DocumentID    string          `json:"documentId"`
+	OwnerID       string          `json:"ownerId"`
+	Collaborators map[string]Role `json:"collaborators"`
+	Links         []ShareLink     `json:"links"`
+}
+
+
+// Range is a span of a document from one character position to another
+type Range struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+
+// CommentThread is a discussion anchored to a range of a document. The
+// range moves with edits to the text around it.
+type CommentThread struct {
+	ID         string    `json:"id"`
+	Range      Range     `json:"range"`
+	Comments   []Comment `json:"comments"`
+	Resolved   bool      `json:"resolved"`
+	ResolvedBy string    `json:"resolvedBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+
+// Comment is the opening comment of a thread or a reply to it
+type Comment struct {
+	ID         string    `json:"id"`
+	AuthorID   string    `json:"authorId"`
+	AuthorName string    `json:"authorName,omitempty"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+
+// Revision is an accepted edit in a document's history, producing the
+// document at that revision from the one before
+type Revision struct {
+	Revision  int       `json:"revision"`
+	UserID    string    `json:"userId"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Ops       []TextOp  `json:"ops"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+
+// LintResult is the analysis of a document at a revision
+type LintResult struct {
+	Revision      int         `json:"revision"`
+	Errors        []LintError `json:"errors"`
+	ExecutionTime int         `json:"executionTime"`
+	Timestamp     time.Time   `json:"timestamp"`
+}
+
+// Usage is linter use counted against quotas: analyses, bytes of code
+// analyzed and milliseconds of linter execution time
+type Usage struct {
+	Analyses      int64 `json:"analyses"`
+	Bytes         int64 `json:"bytes"`
+	ExecutionTime int64 `json:"executionTime"`
+}
+
+// UsageReport is a user's linter use in the current periods and what is left
+// of their quotas, and that of their tenant if they belong to one
+type UsageReport struct {
+	Plan        string                 `json:"plan"`
+	Usage       map[string]QuotaPeriod `json:"usage"`
+	Tenant      string                 `json:"tenant,omitempty"`
+	TenantUsage map[string]QuotaPeriod `json:"tenantUsage,omitempty"`
+}
+
+// QuotaPeriod is the use of each quota in a day or month
+type QuotaPeriod struct {
+	Period        string      `json:"period"`
+	ResetsAt      time.Time   `json:"resetsAt"`
+	Analyses      QuotaMetric `json:"analyses"`
+	Bytes         QuotaMetric `json:"bytes"`
+	ExecutionTime QuotaMetric `json:"executionTime"`
+}
+
+// QuotaMetric is the use of one quota; Limit and Remaining are omitted when
+// it is unlimited
+type QuotaMetric struct {
+	Used      int64  `json:"used"`
+	Limit     *int64 `json:"limit,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+
+// HistoryMessage answers revision history and diff requests
+type HistoryMessage struct {
+	Type       string     `json:"type"`
+	// ID of the request answered, if it had one
+	ID         string     `json:"id,omitempty"`
+	DocumentID string     `json:"documentId"`
+	From       int        `json:"from"`
+	To         int        `json:"to"`
+	Revisions  []Revision `json:"revisions,omitempty"`
+	Ops        []TextOp   `json:"ops,omitempty"`
+	Diff       string     `json:"diff,omitempty"`
 }
 
 
 type Connection struct {
-	UserID   string
+	SessionID   string
+	UserID      string
+	DisplayName string
+	Color       string
+
+	// Document the cursor is in and where, nil when not editing
+	DocumentID string
+	Selection  *Selection
+
 	LastSeen time.Time
+
+	// When the credentials the connection was opened with expire, zero if never
+	ExpiresAt time.Time
+}
+
+
+// Selection is a cursor position with the selected range running from
+// anchor to head; both are equal when nothing is selected
+type Selection struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+
+// Participant describes a connection in a document room to the other members
+type Participant struct {
+	SessionID   string     `json:"sessionId"`
+	UserID      string     `json:"userId"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Color       string     `json:"color,omitempty"`
+	Selection   *Selection `json:"selection,omitempty"`
 }
 
 
@@ -0,0 +1,360 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"codecollab/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	documentsBucket = []byte("documents")
+	threadsBucket   = []byte("threads")
+	usageBucket     = []byte("usage")
+
+	// revisionsBucket, snapshotsBucket and lintsBucket hold a bucket per document, keyed
+	// by big-endian revision number so cursors walk them in order
+	revisionsBucket = []byte("revisions")
+	snapshotsBucket = []byte("snapshots")
+	lintsBucket     = []byte("lints")
+)
+
+// BoltStore keeps documents in an embedded BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := openBolt(path, documentsBucket, threadsBucket, revisionsBucket, snapshotsBucket, lintsBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// openBolt opens the BoltDB file at path, creating it and the buckets if
+// they do not exist
+func openBolt(path string, buckets ...[]byte) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+
+	return db, nil
+}
+
+func (s *BoltStore) GetDocument(ctx context.Context, id string) (*Document, error) {
+	var doc Document
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(documentsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *BoltStore) SaveDocument(ctx context.Context, doc *Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(documentsBucket).Put([]byte(doc.ID), data)
+	})
+}
+
+func (s *BoltStore) AppendRevisions(ctx context.Context, documentID string, revisions []models.Revision) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(revisionsBucket).CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+
+		for _, revision := range revisions {
+			data, err := json.Marshal(revision)
+			if err != nil {
+				return fmt.Errorf("failed to marshal revision: %w", err)
+			}
+			if err := bucket.Put(revisionKey(revision.Revision), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ListRevisions(ctx context.Context, documentID string, from, to int) ([]models.Revision, error) {
+	var revisions []models.Revision
+	err := s.walk(revisionsBucket, documentID, from, to, func(v []byte) error {
+		var revision models.Revision
+		if err := json.Unmarshal(v, &revision); err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (s *BoltStore) SaveSnapshot(ctx context.Context, documentID string, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(snapshotsBucket).CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(revisionKey(snapshot.Revision), data)
+	})
+}
+
+func (s *BoltStore) GetSnapshot(ctx context.Context, documentID string, revision int) (*Snapshot, error) {
+	var snapshot Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(snapshotsBucket).Bucket([]byte(documentID))
+		if bucket == nil {
+			return ErrNotFound
+		}
+
+		// Seek past the revision and step back to the latest one at or before it
+		c := bucket.Cursor()
+		k, v := c.Seek(revisionKey(revision + 1))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &snapshot)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (s *BoltStore) GetThreads(ctx context.Context, documentID string) ([]models.CommentThread, error) {
+	var threads []models.CommentThread
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(threadsBucket).Get([]byte(documentID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &threads)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+func (s *BoltStore) SaveThreads(ctx context.Context, documentID string, threads []models.CommentThread) error {
+	data, err := json.Marshal(threads)
+	if err != nil {
+		return fmt.Errorf("failed to marshal threads: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(threadsBucket).Put([]byte(documentID), data)
+	})
+}
+
+func (s *BoltStore) SaveLintResult(ctx context.Context, documentID string, result *models.LintResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lint result: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(lintsBucket).CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(revisionKey(result.Revision), data)
+	})
+}
+
+func (s *BoltStore) ListLintResults(ctx context.Context, documentID string, from, to int) ([]models.LintResult, error) {
+	var results []models.LintResult
+	err := s.walk(lintsBucket, documentID, from, to, func(v []byte) error {
+		var result models.LintResult
+		if err := json.Unmarshal(v, &result); err != nil {
+			return err
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// walk calls fn with the values stored for revisions from..to of the
+// document, in order
+func (s *BoltStore) walk(name []byte, documentID string, from, to int, fn func(v []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name).Bucket([]byte(documentID))
+		if bucket == nil || from > to {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		last := revisionKey(to)
+		for k, v := c.Seek(revisionKey(from)); k != nil && string(k) <= string(last); k, v = c.Next() {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func revisionKey(revision int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(revision))
+	return key
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// BoltUsageStore counts usage in an embedded BoltDB file
+type BoltUsageStore struct {
+	db *bolt.DB
+
+	// mu guards prunedMonth, the month usage was last pruned in
+	mu          sync.Mutex
+	prunedMonth string
+}
+
+func NewBoltUsageStore(path string) (*BoltUsageStore, error) {
+	db, err := openBolt(path, usageBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltUsageStore{db: db}, nil
+}
+
+// AddUsage prunes the usage of past months in the same transaction the
+// first time it is called in a month, and again if that transaction fails
+func (s *BoltUsageStore) AddUsage(ctx context.Context, keys []string, usage models.Usage) error {
+	month := currentUsageMonth()
+	s.mu.Lock()
+	prune := month != s.prunedMonth
+	s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usageBucket)
+		if prune {
+			if err := pruneUsage(bucket, month); err != nil {
+				return fmt.Errorf("failed to prune usage: %w", err)
+			}
+		}
+
+		for _, key := range keys {
+			var total models.Usage
+			if data := bucket.Get([]byte(key)); data != nil {
+				if err := json.Unmarshal(data, &total); err != nil {
+					return fmt.Errorf("failed to unmarshal usage: %w", err)
+				}
+			}
+
+			data, err := json.Marshal(addUsage(total, usage))
+			if err != nil {
+				return fmt.Errorf("failed to marshal usage: %w", err)
+			}
+			if err := bucket.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if prune {
+		s.mu.Lock()
+		s.prunedMonth = month
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// pruneUsage deletes the keys counting months before the given one
+func pruneUsage(bucket *bolt.Bucket, month string) error {
+	var stale [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if staleUsage(string(k), month) {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltUsageStore) GetUsage(ctx context.Context, key string) (models.Usage, error) {
+	var usage models.Usage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usageBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &usage)
+	})
+	return usage, err
+}
+
+func (s *BoltUsageStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"codecollab/models"
+)
+
+// MemoryStore keeps documents in memory, for tests and single-process development
+type MemoryStore struct {
+	documents map[string]Document
+	revisions map[string]map[int]models.Revision
+	snapshots map[string][]Snapshot
+	lints     map[string]map[int]models.LintResult
+	threads   map[string][]models.CommentThread
+	mu        sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		documents: make(map[string]Document),
+		revisions: make(map[string]map[int]models.Revision),
+		snapshots: make(map[string][]Snapshot),
+		lints:     make(map[string]map[int]models.LintResult),
+		threads:   make(map[string][]models.CommentThread),
+	}
+}
+
+func (s *MemoryStore) GetDocument(ctx context.Context, id string) (*Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	doc, exists := s.documents[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return &doc, nil
+}
+
+func (s *MemoryStore) SaveDocument(ctx context.Context, doc *Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.documents[doc.ID] = *doc
+	return nil
+}
+
+func (s *MemoryStore) AppendRevisions(ctx context.Context, documentID string, revisions []models.Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log, exists := s.revisions[documentID]
+	if !exists {
+		log = make(map[int]models.Revision)
+		s.revisions[documentID] = log
+	}
+	for _, revision := range revisions {
+		log[revision.Revision] = revision
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListRevisions(ctx context.Context, documentID string, from, to int) ([]models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revisions []models.Revision
+	for number, revision := range s.revisions[documentID] {
+		if number >= from && number <= to {
+			revisions = append(revisions, revision)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+func (s *MemoryStore) SaveSnapshot(ctx context.Context, documentID string, snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshots := s.snapshots[documentID]
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].Revision >= snapshot.Revision
+	})
+	if i < len(snapshots) && snapshots[i].Revision == snapshot.Revision {
+		snapshots[i] = *snapshot
+		return nil
+	}
+	snapshots = append(snapshots, Snapshot{})
+	copy(snapshots[i+1:], snapshots[i:])
+	snapshots[i] = *snapshot
+	s.snapshots[documentID] = snapshots
+	return nil
+}
+
+func (s *MemoryStore) GetSnapshot(ctx context.Context, documentID string, revision int) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshots := s.snapshots[documentID]
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].Revision > revision
+	})
+	if i == 0 {
+		return nil, ErrNotFound
+	}
+	snapshot := snapshots[i-1]
+	return &snapshot, nil
+}
+
+func (s *MemoryStore) GetThreads(ctx context.Context, documentID string) ([]models.CommentThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]models.CommentThread(nil), s.threads[documentID]...), nil
+}
+
+func (s *MemoryStore) SaveThreads(ctx context.Context, documentID string, threads []models.CommentThread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.threads[documentID] = append([]models.CommentThread(nil), threads...)
+	return nil
+}
+
+func (s *MemoryStore) SaveLintResult(ctx context.Context, documentID string, result *models.LintResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results, exists := s.lints[documentID]
+	if !exists {
+		results = make(map[int]models.LintResult)
+		s.lints[documentID] = results
+	}
+	results[result.Revision] = *result
+	return nil
+}
+
+func (s *MemoryStore) ListLintResults(ctx context.Context, documentID string, from, to int) ([]models.LintResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []models.LintResult
+	for revision, result := range s.lints[documentID] {
+		if revision >= from && revision <= to {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Revision < results[j].Revision
+	})
+	return results, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// MemoryUsageStore counts usage in memory, so each instance counts its own
+type MemoryUsageStore struct {
+	usage map[string]models.Usage
+	// month usage was last pruned in
+	prunedMonth string
+	mu          sync.RWMutex
+}
+
+func NewMemoryUsageStore() *MemoryUsageStore {
+	return &MemoryUsageStore{
+		usage: make(map[string]models.Usage),
+	}
+}
+
+func (s *MemoryUsageStore) AddUsage(ctx context.Context, keys []string, usage models.Usage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if month := currentUsageMonth(); month != s.prunedMonth {
+		for key := range s.usage {
+			if staleUsage(key, month) {
+				delete(s.usage, key)
+			}
+		}
+		s.prunedMonth = month
+	}
+
+	for _, key := range keys {
+		s.usage[key] = addUsage(s.usage[key], usage)
+	}
+	return nil
+}
+
+func (s *MemoryUsageStore) GetUsage(ctx context.Context, key string) (models.Usage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.usage[key], nil
+}
+
+func (s *MemoryUsageStore) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Document is the persisted state of a collaborative document
+type Document struct {
+	ID        string    `json:"id"`
+	Language  string    `json:"language"`
+	Content   string    `json:"content"`
+	OwnerID   string    `json:"ownerId"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Collaborators maps user IDs to the role they were given by the owner
+	Collaborators map[string]models.Role `json:"collaborators,omitempty"`
+	ShareLinks    []models.ShareLink     `json:"shareLinks,omitempty"`
+}
+
+// Snapshot is the full text of a document at a revision, so older revisions
+// can be rebuilt without replaying the whole revision log
+type Snapshot struct {
+	Revision  int       `json:"revision"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DocumentStore persists documents and their revision history between sessions
+type DocumentStore interface {
+	// GetDocument returns ErrNotFound if the document has never been saved
+	GetDocument(ctx context.Context, id string) (*Document, error)
+	SaveDocument(ctx context.Context, doc *Document) error
+
+	// AppendRevisions adds revisions to the document's log. Revisions are
+	// keyed by number, so appending one again after a failed save is harmless.
+	AppendRevisions(ctx context.Context, documentID string, revisions []models.Revision) error
+	// ListRevisions returns the logged revisions from..to inclusive, in order
+	ListRevisions(ctx context.Context, documentID string, from, to int) ([]models.Revision, error)
+
+	SaveSnapshot(ctx context.Context, documentID string, snapshot *Snapshot) error
+	// GetSnapshot returns the latest snapshot at or before the revision, or
+	// ErrNotFound if there is none
+	GetSnapshot(ctx context.Context, documentID string, revision int) (*Snapshot, error)
+
+	// GetThreads returns the document's comment threads, none if it has no saved threads
+	GetThreads(ctx context.Context, documentID string) ([]models.CommentThread, error)
+	// SaveThreads replaces the document's comment threads
+	SaveThreads(ctx context.Context, documentID string, threads []models.CommentThread) error
+
+	// SaveLintResult records the analysis of a revision, replacing any
+	// earlier one of the same revision
+	SaveLintResult(ctx context.Context, documentID string, result *models.LintResult) error
+	// ListLintResults returns the analyses of revisions from..to inclusive, in order
+	ListLintResults(ctx context.Context, documentID string, from, to int) ([]models.LintResult, error)
+
+	Close() error
+}
+
+// New opens the document store selected in the configuration
+func New(cfg *config.Config) (DocumentStore, error) {
+	switch cfg.DocumentStore {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(cfg.DocumentStorePath)
+	default:
+		return nil, fmt.Errorf("unknown document store: %s", cfg.DocumentStore)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"codecollab/config"
+	"codecollab/models"
+)
+
+// UsageStore accumulates linter use by key, such as a user and a day. Keys
+// ending in a day or month before the current month are pruned as usage is
+// added, since no quota counts them any more.
+type UsageStore interface {
+	// AddUsage adds the usage to the totals of every key at once
+	AddUsage(ctx context.Context, keys []string, usage models.Usage) error
+	// GetUsage returns the total of a key, zero if nothing has been added to it
+	GetUsage(ctx context.Context, key string) (models.Usage, error)
+
+	Close() error
+}
+
+// NewUsageStore opens the usage store selected in the configuration
+func NewUsageStore(cfg *config.Config) (UsageStore, error) {
+	switch cfg.UsageStore {
+	case "memory":
+		return NewMemoryUsageStore(), nil
+	case "bolt":
+		return NewBoltUsageStore(cfg.UsageStorePath)
+	default:
+		return nil, fmt.Errorf("unknown usage store: %s", cfg.UsageStore)
+	}
+}
+
+// usageMonth is the month, as "2006-01", of the day or month a usage key
+// ends with, or "" if it ends with neither
+func usageMonth(key string) string {
+	period := key[strings.LastIndex(key, ":")+1:]
+	if len(period) < 7 {
+		return ""
+	}
+	if _, err := time.Parse("2006-01", period[:7]); err != nil {
+		return ""
+	}
+	return period[:7]
+}
+
+// staleUsage reports whether a usage key counts a month before the given one
+func staleUsage(key, month string) bool {
+	keyMonth := usageMonth(key)
+	return keyMonth != "" && keyMonth < month
+}
+
+func currentUsageMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func addUsage(total, usage models.Usage) models.Usage {
+	total.Analyses += usage.Analyses
+	total.Bytes += usage.Bytes
+	total.ExecutionTime += usage.ExecutionTime
+	return total
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"codecollab/models"
+)
+
+func TestStaleUsage(t *testing.T) {
+	tests := []struct {
+		key   string
+		stale bool
+	}{
+		{"user:user-1:2026-09-30", true},
+		{"user:user-1:2026-09", true},
+		{"tenant:acme:2025-12", true},
+		{"user:user-1:2026-10-01", false},
+		{"user:user-1:2026-10", false},
+		{"user:user-1:2026-11", false},
+		{"user:user-1:latest", false},
+		{"user:user-1:", false},
+	}
+
+	for _, tt := range tests {
+		if stale := staleUsage(tt.key, "2026-10"); stale != tt.stale {
+			t.Errorf("staleUsage(%q) = %v, want %v", tt.key, stale, tt.stale)
+		}
+	}
+}
+
+func TestUsageStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) UsageStore{
+		"memory": func(t *testing.T) UsageStore { return NewMemoryUsageStore() },
+		"bolt": func(t *testing.T) UsageStore {
+			s, err := NewBoltUsageStore(filepath.Join(t.TempDir(), "usage.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := open(t)
+			defer s.Close()
+
+			month := currentUsageMonth()
+			stale := "user:user-1:2000-01"
+			current := "user:user-1:" + month
+			other := "tenant:acme:" + month
+
+			// Usage of an old month is kept until the next prune
+			if err := s.AddUsage(ctx, []string{stale}, models.Usage{Analyses: 1}); err != nil {
+				t.Fatal(err)
+			}
+			setPrunedMonth(s, "2000-01")
+
+			for range 2 {
+				err := s.AddUsage(ctx, []string{current, other}, models.Usage{Analyses: 1, Bytes: 10, ExecutionTime: 5})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := models.Usage{Analyses: 2, Bytes: 20, ExecutionTime: 10}
+			for _, key := range []string{current, other} {
+				if got, err := s.GetUsage(ctx, key); err != nil || got != want {
+					t.Errorf("GetUsage(%q) = %+v, %v, want %+v", key, got, err, want)
+				}
+			}
+			if got, err := s.GetUsage(ctx, stale); err != nil || got != (models.Usage{}) {
+				t.Errorf("GetUsage(%q) = %+v, %v, want it pruned", stale, got, err)
+			}
+		})
+	}
+}
+
+func TestBoltUsageStorePrunesAgainAfterFailure(t *testing.T) {
+	s, err := NewBoltUsageStore(filepath.Join(t.TempDir(), "usage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err := s.AddUsage(context.Background(), []string{"user:user-1:2026-10"}, models.Usage{Analyses: 1}); err == nil {
+		t.Fatal("AddUsage succeeded on a closed store")
+	}
+	if s.prunedMonth != "" {
+		t.Errorf("prunedMonth = %q after a failed update, want it unset", s.prunedMonth)
+	}
+}
+
+func setPrunedMonth(s UsageStore, month string) {
+	switch s := s.(type) {
+	case *MemoryUsageStore:
+		s.prunedMonth = month
+	case *BoltUsageStore:
+		s.prunedMonth = month
+	}
+}
@@ -0,0 +1,60 @@
+const http = require('http');
+const { handler } = require('./index.js');
+
+/**
+ * Plain HTTP wrapper around the Lambda handler so it can run in a container
+ * and be used with the backend's "http" linter backend.
+ *
+ * POST the Lambda event as JSON; the response body is the same
+ * {statusCode, body} envelope the Lambda function returns.
+ *
+ * Environment:
+ *   PORT        - port to listen on (default 9000)
+ *   AUTH_TOKEN  - if set, requests must send "Authorization: <AUTH_TOKEN>"
+ */
+const PORT = process.env.PORT || 9000;
+const AUTH_TOKEN = process.env.AUTH_TOKEN || '';
+const MAX_BODY_SIZE = 1024 * 1024;
+
+const server = http.createServer((req, res) => {
+  if (req.method !== 'POST') {
+    res.writeHead(405, { 'Content-Type': 'text/plain' });
+    res.end('Method not allowed');
+    return;
+  }
+
+  if (AUTH_TOKEN && req.headers.authorization !== AUTH_TOKEN) {
+    res.writeHead(401, { 'Content-Type': 'text/plain' });
+    res.end('Unauthorized');
+    return;
+  }
+
+  let body = '';
+  req.on('data', (chunk) => {
+    body += chunk;
+    if (body.length > MAX_BODY_SIZE) {
+      res.writeHead(413, { 'Content-Type': 'text/plain' });
+      res.end('Request too large');
+      req.destroy();
+    }
+  });
+
+  req.on('end', async () => {
+    let event;
+    try {
+      event = JSON.parse(body);
+    } catch (e) {
+      res.writeHead(400, { 'Content-Type': 'text/plain' });
+      res.end('Invalid JSON');
+      return;
+    }
+
+    const result = await handler(event);
+    res.writeHead(200, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(result));
+  });
+});
+
+server.listen(PORT, () => {
+  console.log(`TypeScript linter listening on port ${PORT}`);
+});